@@ -1,14 +1,26 @@
 package main
 
 import (
-	"log"
+	"context"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/ffjabbari/go-microservice-sample/cmd/contactapp/handler"
+	"github.com/ffjabbari/go-microservice-sample/internal/auth"
 	"github.com/ffjabbari/go-microservice-sample/internal/cache"
 	"github.com/ffjabbari/go-microservice-sample/internal/config"
 	"github.com/ffjabbari/go-microservice-sample/internal/contacts"
 	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/idempotency"
+	"github.com/ffjabbari/go-microservice-sample/internal/idgen"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+	"github.com/ffjabbari/go-microservice-sample/internal/ratelimit"
+	"github.com/ffjabbari/go-microservice-sample/internal/shard"
+	"github.com/ffjabbari/go-microservice-sample/internal/tracing"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -20,17 +32,90 @@ func init() {
 		"../../files/config/config-development.json",
 	)
 
+	// init logger before anything else that may log
+	err := logger.Init(conf.Log)
+	if err != nil {
+		logger.Fatal(context.Background(), "fail init logger", "error", err)
+	}
+
 	// open database connection
 	database.ConnectDB(conf.Database)
 
-	// open redis connection
+	// contacts spread over several databases
+	err = shard.Configure(conf.Sharding)
+	if err != nil {
+		logger.Fatal(context.Background(), "invalid sharding config", "error", err)
+	}
+
+	// open redis connection, redis is bypassed while it keeps failing
+	cache.ConfigureBreaker(conf.RedisBreaker)
 	cache.ConnectRedis(conf.Redis)
+
+	// expose db pool stats
+	metrics.CollectDBStats(database.Stats)
 }
 
 func main() {
 
+	// `contactapp migrate ...` change the schema then exit without serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	// `contactapp reshard ...` move contacts to their new shard then exit
+	if len(os.Args) > 1 && os.Args[1] == "reshard" {
+		os.Exit(runReshard(os.Args[2:]))
+	}
+
 	conf := config.Get()
 
+	// bring schema up to date before anything query it
+	if conf.Migrate.Auto {
+		autoMigrate()
+	}
+
+	// init tracer provider
+	shutdownTracing, err := tracing.Init(conf.Tracing)
+	if err != nil {
+		logger.Fatal(context.Background(), "fail init tracing", "error", err)
+	}
+
+	// load jwt keys
+	err = auth.Init(conf.Auth)
+	if err != nil {
+		logger.Fatal(context.Background(), "fail init auth", "error", err)
+	}
+
+	// per client and per route limits
+	err = ratelimit.Init(conf.RateLimit)
+	if err != nil {
+		logger.Fatal(context.Background(), "invalid rate limit config", "error", err)
+	}
+
+	// replay of mutating requests retried with Idempotency-Key
+	err = idempotency.Init(conf.Idempotency)
+	if err != nil {
+		logger.Fatal(context.Background(), "invalid idempotency config", "error", err)
+	}
+
+	// read-your-writes token sent to clients
+	err = database.ConfigureConsistency(conf.Consistency)
+	if err != nil {
+		logger.Fatal(context.Background(), "invalid consistency config", "error", err)
+	}
+
+	// tenants that have dedicated database
+	contacts.MapTenants(conf.Tenants)
+
+	// contact ids are generated by this instance
+	err = idgen.Configure(conf.IDGen)
+	if err != nil {
+		logger.Fatal(context.Background(), "invalid id generator config", "error", err)
+	}
+
+	// expiry of cached contacts and list pages
+	contacts.ConfigureCache(conf.ContactCache)
+
 	handler.Init()
 
 	// init contacts package
@@ -40,13 +125,38 @@ func main() {
 
 	// router obj
 	router := httprouter.New()
-	router.POST("/v1/contacts", handler.NewContact)
-	router.PATCH("/v1/contacts/:contact_id", handler.UpdateContact)
-	router.GET("/v1/contacts", handler.ListContact)
-	router.GET("/v1/contacts/:contact_id", handler.GetContact)
-	router.DELETE("/v1/contacts/:contact_id", handler.DeleteContact)
+	router.POST("/v1/contacts", handler.Wrap("/v1/contacts", handler.NewContact))
+	router.PATCH("/v1/contacts/:contact_id", handler.Wrap("/v1/contacts/:contact_id", handler.UpdateContact))
+	router.GET("/v1/contacts", handler.Wrap("/v1/contacts", handler.ListContact))
+	router.GET("/v1/contacts/:contact_id", handler.Wrap("/v1/contacts/:contact_id", handler.GetContact))
+	router.DELETE("/v1/contacts/:contact_id", handler.Wrap("/v1/contacts/:contact_id", handler.DeleteContact))
+	router.GET("/v1/contacts/:contact_id/shares", handler.Wrap("/v1/contacts/:contact_id/shares", handler.ListShare))
+	router.POST("/v1/contacts/:contact_id/shares", handler.Wrap("/v1/contacts/:contact_id/shares", handler.NewShare))
+	router.DELETE("/v1/contacts/:contact_id/shares/:grantee", handler.Wrap("/v1/contacts/:contact_id/shares/:grantee", handler.DeleteShare))
+
+	// prometheus metrics
+	router.Handler("GET", "/metrics", metrics.Handler())
+
+	// health check, it doesn't require authentication
+	router.GET("/healthz", handler.Healthz)
 
 	//run http server
-	log.Fatal(http.ListenAndServe(conf.Port, router))
-//test
+	server := &http.Server{Addr: conf.Port, Handler: router}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatal(context.Background(), "http server stopped", "error", err)
+		}
+	}()
+
+	// wait for stop signal, then flush pending spans before exit
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server.Shutdown(ctx)
+	shutdownTracing(ctx)
 }
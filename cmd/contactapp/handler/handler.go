@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/ffjabbari/go-microservice-sample/internal/contacts"
+	"github.com/ffjabbari/go-microservice-sample/internal/idgen"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -39,13 +40,25 @@ func NewContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		return
 	}
 
-	_, err = pkgcontact.Create(input)
+	cObj, err := pkgcontact.Create(r.Context(), input)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(errorStatus(err))
 		return
 	}
 
+	data := cObj.Data()
+	links := contactLinks(data.ID)
+
+	// prepare result header
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", links["self"])
 	w.WriteHeader(http.StatusCreated)
+
+	// write result
+	res := Response{Data: data, Links: links}
+	jsonByte, _ := json.Marshal(res)
+	w.Write(jsonByte)
+
 	return
 
 }
@@ -65,9 +78,9 @@ func ListContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 		page = 1
 	}
 
-	data, err := pkgcontact.List(take, page)
+	data, err := pkgcontact.List(r.Context(), take, page)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
 		return
 	}
 
@@ -85,15 +98,21 @@ func ListContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
 // GetContact is for get 1 contact data by id
 func GetContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	contactID, _ := strconv.ParseInt(p.ByName("contact_id"), 10, 64)
+	contactID := contactIDParam(p)
 	if contactID == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	cObj, err := pkgcontact.Get(contactID)
+	cObj, err := pkgcontact.Get(r.Context(), contactID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+
+	// if contactID not found or not visible
+	if cObj == nil {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
@@ -112,7 +131,7 @@ func GetContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 // UpdateContact is for updating contact data
 func UpdateContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	// get param contact id
-	contactID, _ := strconv.ParseInt(p.ByName("contact_id"), 10, 64)
+	contactID := contactIDParam(p)
 	if contactID == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -128,21 +147,21 @@ func UpdateContact(w http.ResponseWriter, r *http.Request, p httprouter.Params)
 		return
 	}
 
-	cObj, err := pkgcontact.Get(contactID)
+	cObj, err := pkgcontact.Get(r.Context(), contactID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
 		return
 	}
 
-	// if contactID not found
+	// if contactID not found or not visible
 	if cObj == nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	err = cObj.Update(input)
+	err = cObj.Update(r.Context(), input)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
 		return
 	}
 
@@ -161,24 +180,160 @@ func UpdateContact(w http.ResponseWriter, r *http.Request, p httprouter.Params)
 // DeleteContact is for deleting 1 contact based on contact id
 func DeleteContact(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	// get param contact id
-	contactID, _ := strconv.ParseInt(p.ByName("contact_id"), 10, 64)
+	contactID := contactIDParam(p)
 	if contactID == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	cObj, err := pkgcontact.Get(contactID)
+	cObj, err := pkgcontact.Get(r.Context(), contactID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+
+	// if contactID not found or not visible
+	if cObj == nil {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	err = cObj.Delete()
+	err = cObj.Delete(r.Context())
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(errorStatus(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 	return
 }
+
+// ListShare is for get list of grants of 1 contact
+func ListShare(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	cObj, status := getContact(r, p)
+	if cObj == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	shares, err := cObj.Shares(r.Context())
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+
+	// prepare result header
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	// write result
+	res := Response{Data: shares}
+	jsonByte, _ := json.Marshal(res)
+	w.Write(jsonByte)
+
+	return
+}
+
+// NewShare is for grant 1 contact to other principal
+func NewShare(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	// get json input data
+	decoder := json.NewDecoder(r.Body)
+	var input contacts.Share
+	err := decoder.Decode(&input)
+
+	if err != nil || input.Grantee == "" ||
+		(input.Permission != contacts.PermissionRead && input.Permission != contacts.PermissionWrite) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cObj, status := getContact(r, p)
+	if cObj == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	err = cObj.Share(r.Context(), input)
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	return
+}
+
+// DeleteShare is for revoke grant of 1 contact
+func DeleteShare(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	cObj, status := getContact(r, p)
+	if cObj == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	err := cObj.Unshare(r.Context(), p.ByName("grantee"))
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return
+}
+
+// getContact get contact from contact_id param
+// status is the response status to use when contact is nil
+func getContact(r *http.Request, p httprouter.Params) (contacts.Contact, int) {
+	contactID := contactIDParam(p)
+	if contactID == 0 {
+		return nil, http.StatusBadRequest
+	}
+
+	cObj, err := pkgcontact.Get(r.Context(), contactID)
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+
+	if cObj == nil {
+		return nil, http.StatusNotFound
+	}
+
+	return cObj, http.StatusOK
+}
+
+// contactIDParam parse contact_id param, it's zero when it isn't an id
+// numeric id of contacts created before ids were generated is still accepted
+func contactIDParam(p httprouter.Params) int64 {
+	contactID, err := idgen.Parse(p.ByName("contact_id"))
+	if err != nil {
+		return 0
+	}
+
+	return contactID
+}
+
+// contactLinks return links of 1 contact resource
+func contactLinks(contactID int64) map[string]string {
+	self := "/v1/contacts/" + idgen.Format(contactID)
+
+	return map[string]string{
+		"self":   self,
+		"shares": self + "/shares",
+	}
+}
+
+// errorStatus map error from contacts package to response status
+func errorStatus(err error) int {
+	switch err {
+	case contacts.ErrInvalidContact, contacts.ErrInvalidShare:
+		return http.StatusBadRequest
+	case contacts.ErrForbidden, contacts.ErrNoTenant:
+		return http.StatusForbidden
+	case contacts.ErrNotFound:
+		return http.StatusNotFound
+	case contacts.ErrConflict:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
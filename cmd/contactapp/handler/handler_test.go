@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"io"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/ffjabbari/go-microservice-sample/internal/contacts"
 	"github.com/ffjabbari/go-microservice-sample/internal/mocks/mockcontacts"
 
 	"github.com/julienschmidt/httprouter"
@@ -53,21 +55,58 @@ func TestCreateContact(t *testing.T) {
 	method := "POST"
 	target := "http://www.example.com/v1/contacts"
 
+	// created contact get id from database
+	defaultCreate := mockcontacts.ReturnCreate
+	defer func() { mockcontacts.ReturnCreate = defaultCreate }()
+
 	testCase := []struct {
-		Body      io.Reader
-		ResStatus int
+		Body        io.Reader
+		CreateErr   error
+		ResStatus   int
+		ResLocation string
 	}{
 		{
 			strings.NewReader(`{"name":"User1", "email":"user1@email.com", "phone":"+628123456789"}`),
+			nil,
 			201,
+			"/v1/contacts/0000000000007",
 		},
 		{
 			strings.NewReader(`{"name":"Uer3 !@#" "email":"user1@email.com", "phone":"+628123456789"}`),
+			nil,
 			400,
+			"",
+		},
+		{
+			strings.NewReader(`{"name":"User1", "email":"user1@email.com", "phone":"+628123456789"}`),
+			contacts.ErrInvalidContact,
+			400,
+			"",
+		},
+		{
+			strings.NewReader(`{"name":"User1", "email":"user1@email.com", "phone":"+628123456789"}`),
+			contacts.ErrConflict,
+			409,
+			"",
+		},
+		{
+			strings.NewReader(`{"name":"User1", "email":"user1@email.com", "phone":"+628123456789"}`),
+			errors.New("connection refused"),
+			500,
+			"",
 		},
 	}
 
 	for index, tcase := range testCase {
+		createErr := tcase.CreateErr
+		mockcontacts.ReturnCreate = func(cData contacts.ContactData) (contacts.Contact, error) {
+			if createErr != nil {
+				return nil, createErr
+			}
+			cData.ID = 7
+			return mockcontacts.ReturnGet(cData.ID)
+		}
+
 		req := httptest.NewRequest(method, target, tcase.Body)
 		w := httptest.NewRecorder()
 		p := httprouter.Params{}
@@ -78,5 +117,133 @@ func TestCreateContact(t *testing.T) {
 		if resp.StatusCode != tcase.ResStatus {
 			t.Errorf("[TestCreateContact] tcase:%v res got %v | expect %v", index, resp.StatusCode, tcase.ResStatus)
 		}
+
+		if location := resp.Header.Get("Location"); location != tcase.ResLocation {
+			t.Errorf("[TestCreateContact] tcase:%v location got %v | expect %v", index, location, tcase.ResLocation)
+		}
+
+		if tcase.ResLocation != "" && !strings.Contains(w.Body.String(), `"id":"0000000000007"`) {
+			t.Errorf("[TestCreateContact] tcase:%v body got %v | expect created contact", index, w.Body.String())
+		}
+	}
+}
+
+func TestNewShare(t *testing.T) {
+	method := "POST"
+	target := "http://www.example.com/v1/contacts/1/shares"
+
+	testCase := []struct {
+		Body      io.Reader
+		ShareErr  error
+		ResStatus int
+	}{
+		{
+			strings.NewReader(`{"grantee":"user2", "permission":"read"}`),
+			nil,
+			201,
+		},
+		{
+			strings.NewReader(`{"grantee":"user2", "permission":"owner"}`),
+			nil,
+			400,
+		},
+		{
+			strings.NewReader(`{"grantee":"user2", "permission":"write"}`),
+			contacts.ErrForbidden,
+			403,
+		},
+		{
+			strings.NewReader(`{"grantee":"user2", "permission":"write"}`),
+			contacts.ErrInvalidShare,
+			400,
+		},
+	}
+
+	for index, tcase := range testCase {
+		shareErr := tcase.ShareErr
+		mockcontacts.McShare = func(share contacts.Share) error {
+			return shareErr
+		}
+
+		req := httptest.NewRequest(method, target, tcase.Body)
+		w := httptest.NewRecorder()
+		p := httprouter.Params{httprouter.Param{Key: "contact_id", Value: "1"}}
+		NewShare(w, req, p)
+
+		resp := w.Result()
+
+		if resp.StatusCode != tcase.ResStatus {
+			t.Errorf("[TestNewShare] tcase:%v res got %v | expect %v", index, resp.StatusCode, tcase.ResStatus)
+		}
+	}
+}
+
+func TestContactIDParam(t *testing.T) {
+	testCase := []struct {
+		Value    string
+		Expected int64
+	}{
+		{"0000000000007", 7},
+		// numeric id of contacts created before ids were generated
+		{"7", 7},
+		{"000000000000U", 0},
+		{"abc", 0},
+		{"", 0},
+	}
+
+	for index, tcase := range testCase {
+		p := httprouter.Params{httprouter.Param{Key: "contact_id", Value: tcase.Value}}
+		if got := contactIDParam(p); got != tcase.Expected {
+			t.Errorf("[TestContactIDParam] tcase:%v got %v | expected %v", index, got, tcase.Expected)
+		}
+	}
+}
+
+func TestUpdateContact(t *testing.T) {
+	method := "PATCH"
+	target := "http://www.example.com/v1/contacts/1"
+
+	defaultGet := mockcontacts.ReturnGet
+	defaultUpdate := mockcontacts.McUpdate
+	defer func() {
+		mockcontacts.ReturnGet = defaultGet
+		mockcontacts.McUpdate = defaultUpdate
+	}()
+
+	testCase := []struct {
+		Found     bool
+		UpdateErr error
+		ResStatus int
+	}{
+		{true, nil, 200},
+		// not found or not visible
+		{false, nil, 404},
+		{true, contacts.ErrInvalidContact, 400},
+		{true, contacts.ErrNotFound, 404},
+		{true, errors.New("connection refused"), 500},
+	}
+
+	for index, tcase := range testCase {
+		updateErr := tcase.UpdateErr
+		mockcontacts.McUpdate = func(input contacts.ContactData) error {
+			return updateErr
+		}
+
+		found := tcase.Found
+		mockcontacts.ReturnGet = func(contactID int64) (contacts.Contact, error) {
+			if !found {
+				return nil, nil
+			}
+			return defaultGet(contactID)
+		}
+
+		req := httptest.NewRequest(method, target, strings.NewReader(`{"name":"User1"}`))
+		w := httptest.NewRecorder()
+		p := httprouter.Params{httprouter.Param{Key: "contact_id", Value: "1"}}
+		UpdateContact(w, req, p)
+
+		if resp := w.Result(); resp.StatusCode != tcase.ResStatus {
+			t.Errorf("[TestUpdateContact] tcase:%v res got %v | expect %v", index, resp.StatusCode, tcase.ResStatus)
+		}
 	}
 }
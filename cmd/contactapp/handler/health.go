@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/cache"
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type (
+	// health is the result of health check
+	health struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+)
+
+// Healthz is for report state of dependencies
+// redis being bypassed or replica being down or lagging degrades the service, it's
+// reported as degraded with 200, since every instance share that state and taking
+// them out of rotation together turns degradation into outage, only master that
+// isn't connected yet make the instance unavailable with 503
+func Healthz(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	res := health{Status: "ok", Checks: make(map[string]string)}
+
+	for name, state := range cache.Health() {
+		res.Checks["redis:"+name] = state
+		if state != "closed" {
+			res.Status = "degraded"
+		}
+	}
+
+	// failing replica is skipped, reads fall back to master when every replica fail
+	for name, state := range database.Health() {
+		res.Checks["database:"+name] = state
+		if state != "up" {
+			res.Status = "degraded"
+		}
+	}
+
+	// lazy master is connected in background, nothing can be served until it answers
+	available := true
+	for _, dbname := range database.Names() {
+		select {
+		case <-database.Ready(dbname):
+			res.Checks["database:"+dbname+":master"] = "up"
+		default:
+			res.Checks["database:"+dbname+":master"] = "connecting"
+			available = false
+		}
+	}
+	if !available {
+		res.Status = "unavailable"
+	}
+
+	// prepare result header
+	w.Header().Set("Content-Type", "application/json")
+	status := http.StatusOK
+	if !available {
+		status = http.StatusServiceUnavailable
+	}
+	w.WriteHeader(status)
+
+	// write result
+	jsonByte, _ := json.Marshal(Response{Data: res})
+	w.Write(jsonByte)
+}
@@ -0,0 +1,444 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/auth"
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/contacts"
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/idempotency"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+	"github.com/ffjabbari/go-microservice-sample/internal/ratelimit"
+	"github.com/ffjabbari/go-microservice-sample/internal/tracing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type (
+	// Middleware wrap route handler, route is the registered path pattern
+	Middleware func(route string, h httprouter.Handle) httprouter.Handle
+
+	// statusRecorder keeps the status code and size written by the wrapped handler
+	statusRecorder struct {
+		http.ResponseWriter
+		status int
+		bytes  int
+	}
+
+	// tokenWriter set consistency token header right before response is written
+	tokenWriter struct {
+		http.ResponseWriter
+		ctx         context.Context
+		wroteHeader bool
+	}
+
+	// responseCapture keeps a copy of the response written by the wrapped handler
+	responseCapture struct {
+		http.ResponseWriter
+		status int
+		body   bytes.Buffer
+	}
+
+	// authResult is outcome of authenticating one request,
+	// RateLimit authenticate before Authenticate and share it through context
+	authResult struct {
+		principal *auth.Principal
+		err       error
+	}
+
+	authResultKey struct{}
+)
+
+const (
+	// requestIDHeader is used to receive and return request id
+	requestIDHeader = "X-Request-ID"
+
+	// idempotencyKeyHeader is used to make retry of mutating request safe
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// consistencyHeader carry token that make reads see earlier writes of the client
+	consistencyHeader = "X-Consistency-Token"
+
+	// maxIdempotentBody is the largest request body fingerprinted for idempotency key
+	maxIdempotentBody = 1 << 20
+)
+
+// replayedHeaders are response headers stored with idempotent response,
+// the others are set per request by outer middlewares
+var replayedHeaders = []string{"Content-Type", "Location", consistencyHeader}
+
+// middlewares applied by Wrap, the first one is the outermost
+var middlewares = []Middleware{
+	Trace,
+	RequestID,
+	AccessLog,
+	Instrument,
+	RateLimit,
+	Authenticate,
+	Idempotency,
+	Consistency,
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+func (tw *tokenWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		if token := database.SessionToken(tw.ctx); token != "" {
+			tw.Header().Set(consistencyHeader, token)
+		}
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *tokenWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (rc *responseCapture) WriteHeader(status int) {
+	rc.status = status
+	rc.ResponseWriter.WriteHeader(status)
+}
+
+func (rc *responseCapture) Write(b []byte) (int, error) {
+	rc.body.Write(b)
+	return rc.ResponseWriter.Write(b)
+}
+
+// Wrap apply all middlewares to route handler
+// route is the registered path pattern, not the requested path,
+// so it's safe to be used as metric label
+func Wrap(route string, h httprouter.Handle) httprouter.Handle {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](route, h)
+	}
+
+	return h
+}
+
+// Instrument record request count and latency of a route
+func Instrument(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h(rec, r, p)
+
+		metrics.ObserveRequest(route, r.Method, rec.status, time.Since(start))
+	}
+}
+
+// Trace start server span for every request, continuing trace from traceparent header
+// span is passed to handler through request context
+func Trace(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		ctx, span := tracing.StartServer(r, route)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h(rec, r.WithContext(ctx), p)
+
+		tracing.SetHTTPStatus(span, rec.status)
+	}
+}
+
+// RequestID take request id from X-Request-ID header or generate new one,
+// store it in request context and return it in response header
+func RequestID(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = logger.NewRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+
+		h(w, r.WithContext(logger.WithRequestID(r.Context(), requestID)), p)
+	}
+}
+
+// AccessLog write one log line for every request
+func AccessLog(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h(rec, r, p)
+
+		logger.Info(r.Context(), "access",
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.RequestURI(),
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration_ms", time.Since(start).Seconds()*1000,
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+		)
+	}
+}
+
+// Authenticate reject request without valid jwt or api key,
+// the principal and its tenant are passed to handler through request context
+func Authenticate(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		r, principal, err := authenticate(r)
+		if err == auth.ErrNoCredentials || err == auth.ErrInvalidCredentials {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="contactapp"`)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			logger.Error(r.Context(), "fail authenticate request", "error", err)
+			writeError(w, http.StatusInternalServerError, "authentication unavailable")
+			return
+		}
+
+		// every contact belongs to a tenant, caller without tenant can't access anything
+		if principal.TenantID == "" {
+			writeError(w, http.StatusForbidden, "no tenant")
+			return
+		}
+
+		ctx := auth.WithPrincipal(r.Context(), principal)
+		ctx = contacts.WithTenant(ctx, principal.TenantID)
+
+		h(w, r.WithContext(ctx), p)
+	}
+}
+
+// authenticate return principal of the request, it's authenticated once per request
+// returned request carry the result for the next call
+func authenticate(r *http.Request) (*http.Request, *auth.Principal, error) {
+	if res, ok := r.Context().Value(authResultKey{}).(*authResult); ok {
+		return r, res.principal, res.err
+	}
+
+	principal, err := auth.Authenticate(r)
+	res := &authResult{principal: principal, err: err}
+
+	return r.WithContext(context.WithValue(r.Context(), authResultKey{}, res)), principal, err
+}
+
+// Consistency start read-your-writes session from X-Consistency-Token header,
+// write in the request return new token in the same header
+func Consistency(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		// token is bound to the principal, so it can't be replayed by other client
+		principal := ""
+		if pr, ok := auth.FromContext(r.Context()); ok {
+			principal = pr.TenantID + ":" + pr.Subject
+		}
+		ctx := database.WithSession(r.Context(), r.Header.Get(consistencyHeader), principal)
+
+		h(&tokenWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx), p)
+	}
+}
+
+// writeError write json error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	jsonByte, _ := json.Marshal(Response{Error: message})
+	w.Write(jsonByte)
+}
+
+// RateLimit reject request when the client run out of its client or route limit
+// it runs before Authenticate, so failed authentication is limited too: client is
+// the principal when authentication succeed, otherwise the ip of the caller
+func RateLimit(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if !ratelimit.Enabled() {
+			h(w, r, p)
+			return
+		}
+
+		anonymous, limitAnonymous := ratelimit.AnonymousLimit()
+		ipClient := "anonymous:" + clientIP(r)
+
+		// ip that ran out of its limit isn't authenticated again, so flood of
+		// bad credentials doesn't reach api key lookup
+		if limitAnonymous {
+			if res := ratelimit.Peek(r.Context(), ipClient, anonymous); !res.Allowed {
+				rejectRateLimited(w, res)
+				return
+			}
+		}
+
+		r, principal, err := authenticate(r)
+
+		// buckets keyed by bucket key
+		buckets := make(map[string]config.Limit)
+		if err == nil {
+			client := principal.TenantID + ":" + principal.Subject
+			for name, limit := range ratelimit.Limits(r.Method, route) {
+				buckets[name+":"+client] = limit
+			}
+		} else if limitAnonymous {
+			buckets[ipClient] = anonymous
+		}
+
+		// report the most restrictive limit in headers
+		var reported *ratelimit.Result
+		for key, limit := range buckets {
+			res := ratelimit.Allow(r.Context(), key, limit)
+			if !res.Allowed {
+				rejectRateLimited(w, res)
+				return
+			}
+
+			if reported == nil || res.Remaining < reported.Remaining {
+				reported = &res
+			}
+		}
+
+		if reported != nil {
+			setRateLimitHeaders(w, *reported)
+		}
+
+		h(w, r, p)
+	}
+}
+
+func rejectRateLimited(w http.ResponseWriter, res ratelimit.Result) {
+	setRateLimitHeaders(w, res)
+	w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+	writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+}
+
+// clientIP return ip of the caller, forwarded headers are ignored since caller can set them
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	w.Header().Set("RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+	w.Header().Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// Idempotency replay stored response when mutating request is retried with the same Idempotency-Key
+// key is scoped to the authenticated principal, so it must run after Authenticate
+func Idempotency(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		principal, ok := auth.FromContext(r.Context())
+		if !idempotency.Enabled() || !ok || key == "" || !mutating(r.Method) {
+			h(w, r, p)
+			return
+		}
+
+		if len(key) > 255 {
+			writeError(w, http.StatusBadRequest, "idempotency key too long")
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "fail read request body")
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		key = principal.TenantID + ":" + principal.Subject + ":" + key
+		fingerprint := idempotency.Fingerprint(r.Method, r.URL.Path, body)
+
+		rec, err := idempotency.Begin(r.Context(), key, fingerprint)
+		switch {
+		case err == idempotency.ErrMismatch:
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+
+		case err == idempotency.ErrInProgress:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusConflict, err.Error())
+			return
+
+		case err != nil:
+			// request isn't processed without the key, retry of it could apply it twice
+			logger.Error(r.Context(), "idempotency unavailable", "error", err)
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "idempotency unavailable")
+			return
+
+		case rec != nil:
+			for name, values := range rec.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(rec.Status)
+			w.Write(rec.Body)
+			return
+		}
+
+		rc := &responseCapture{ResponseWriter: w, status: http.StatusOK}
+		h(rc, r, p)
+
+		// server error isn't stored, so the client can retry with the same key
+		if rc.status >= http.StatusInternalServerError {
+			idempotency.Release(r.Context(), key)
+			return
+		}
+
+		header := http.Header{}
+		for _, name := range replayedHeaders {
+			if values, ok := w.Header()[name]; ok {
+				header[name] = values
+			}
+		}
+
+		idempotency.Complete(r.Context(), key, idempotency.Record{
+			Fingerprint: fingerprint,
+			Status:      rc.status,
+			Header:      header,
+			Body:        rc.body.Bytes(),
+		})
+	}
+}
+
+func mutating(method string) bool {
+	switch method {
+	case "POST", "PUT", "PATCH", "DELETE":
+		return true
+	}
+
+	return false
+}
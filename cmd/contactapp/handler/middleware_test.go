@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/auth"
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/idempotency"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/ratelimit"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestInstrument(t *testing.T) {
+	testCase := []struct {
+		Handle    httprouter.Handle
+		ResStatus int
+	}{
+		{
+			func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+				w.WriteHeader(http.StatusCreated)
+			},
+			201,
+		},
+		{
+			func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+				w.Write([]byte("ok"))
+			},
+			200,
+		},
+	}
+
+	for index, tcase := range testCase {
+		req := httptest.NewRequest("GET", "http://www.example.com/v1/contacts", nil)
+		w := httptest.NewRecorder()
+
+		// make sure the recorder see the same status as the client
+		var recorded int
+		h := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			tcase.Handle(w, r, p)
+			recorded = w.(*statusRecorder).status
+		}
+		Instrument("/v1/contacts", h)(w, req, httprouter.Params{})
+
+		resp := w.Result()
+
+		if resp.StatusCode != tcase.ResStatus {
+			t.Errorf("[TestInstrument] tcase:%v res got %v | expect %v", index, resp.StatusCode, tcase.ResStatus)
+		}
+
+		if recorded != tcase.ResStatus {
+			t.Errorf("[TestInstrument] tcase:%v recorded got %v | expect %v", index, recorded, tcase.ResStatus)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	testCase := []struct {
+		Header   string
+		Expected string
+	}{
+		{
+			"req-123",
+			"req-123",
+		},
+		{
+			"",
+			"",
+		},
+	}
+
+	for index, tcase := range testCase {
+		req := httptest.NewRequest("GET", "http://www.example.com/v1/contacts", nil)
+		if tcase.Header != "" {
+			req.Header.Set("X-Request-ID", tcase.Header)
+		}
+		w := httptest.NewRecorder()
+
+		var ctxID string
+		h := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			ctxID = logger.RequestID(r.Context())
+		}
+		RequestID("/v1/contacts", h)(w, req, httprouter.Params{})
+
+		resID := w.Result().Header.Get("X-Request-ID")
+		if resID == "" || resID != ctxID {
+			t.Errorf("[TestRequestID] tcase:%v header got %v | context got %v", index, resID, ctxID)
+		}
+
+		if tcase.Expected != "" && resID != tcase.Expected {
+			t.Errorf("[TestRequestID] tcase:%v res got %v | expect %v", index, resID, tcase.Expected)
+		}
+	}
+}
+
+func TestRateLimitAnonymous(t *testing.T) {
+	ratelimit.Init(config.RateLimit{
+		Enabled:   true,
+		Anonymous: config.Limit{Requests: 2, Seconds: 60},
+	})
+	defer ratelimit.Init(config.RateLimit{})
+
+	called := 0
+	h := RateLimit("/v1/contacts", Authenticate("/v1/contacts", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		called++
+	}))
+
+	testCase := []struct {
+		RemoteAddr string
+		ResStatus  int
+	}{
+		{"192.0.2.1:1234", 401},
+		{"192.0.2.1:1235", 401},
+		// ip ran out of its limit, it isn't authenticated again
+		{"192.0.2.1:1236", 429},
+		{"192.0.2.2:1234", 401},
+	}
+
+	for index, tcase := range testCase {
+		req := httptest.NewRequest("GET", "http://www.example.com/v1/contacts", nil)
+		req.RemoteAddr = tcase.RemoteAddr
+		w := httptest.NewRecorder()
+		h(w, req, httprouter.Params{})
+
+		if resp := w.Result(); resp.StatusCode != tcase.ResStatus {
+			t.Errorf("[TestRateLimitAnonymous] tcase:%v res got %v | expect %v", index, resp.StatusCode, tcase.ResStatus)
+		}
+	}
+
+	if called != 0 {
+		t.Errorf("[TestRateLimitAnonymous] handler called %v times | expect 0", called)
+	}
+}
+
+func TestIdempotencyUnavailable(t *testing.T) {
+	// redis connection doesn't exist in this test, so the key can't be claimed
+	idempotency.Init(config.Idempotency{Enabled: true, Cache: "missing"})
+	defer idempotency.Init(config.Idempotency{})
+
+	called := false
+	h := Idempotency("/v1/contacts", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		called = true
+	})
+
+	req := httptest.NewRequest("POST", "http://www.example.com/v1/contacts", strings.NewReader(`{}`))
+	req.Header.Set(idempotencyKeyHeader, "key1")
+	req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Subject: "user1", TenantID: "tenant1"}))
+	w := httptest.NewRecorder()
+	h(w, req, httprouter.Params{})
+
+	if resp := w.Result(); resp.StatusCode != http.StatusServiceUnavailable || called {
+		t.Errorf("[TestIdempotencyUnavailable] res got %v, handler called %v | expect 503 without calling handler", resp.StatusCode, called)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/migrate"
+)
+
+const migrateUsage = "usage: contactapp migrate up | down [steps] | status"
+
+// runMigrate run `contactapp migrate` against master of every database and return exit code
+// down revert one migration unless steps is given
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	steps := 1
+	if args[0] == "down" && len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		steps = n
+	}
+
+	ctx := context.Background()
+	for _, dbname := range database.Names() {
+		db, err := database.Conn(dbname, "master")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		switch args[0] {
+		case "up":
+			applied, err := migrate.Up(ctx, db)
+			for _, m := range applied {
+				fmt.Printf("%s\tapplied\t%04d_%s\n", dbname, m.Version, m.Name)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", dbname, err)
+				return 1
+			}
+
+		case "down":
+			reverted, err := migrate.Down(ctx, db, steps)
+			for _, m := range reverted {
+				fmt.Printf("%s\treverted\t%04d_%s\n", dbname, m.Version, m.Name)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", dbname, err)
+				return 1
+			}
+
+		case "status":
+			states, err := migrate.Status(ctx, db)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", dbname, err)
+				return 1
+			}
+			for _, s := range states {
+				appliedAt := "pending"
+				if s.AppliedAt != nil {
+					appliedAt = s.AppliedAt.Format("2006-01-02T15:04:05Z07:00")
+				}
+				fmt.Printf("%s\t%04d_%s\t%s\n", dbname, s.Version, s.Name, appliedAt)
+			}
+
+		default:
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+	}
+
+	return 0
+}
+
+// autoMigrate apply pending migrations to every database before serving requests
+// lazy database that doesn't answer yet is migrated in background once it answers,
+// requests to it fail until then anyway
+func autoMigrate() {
+	for _, dbname := range database.Names() {
+		select {
+		case <-database.Ready(dbname):
+			migrateDB(dbname)
+		default:
+			logger.Info(context.Background(), "database isn't reachable yet, auto migrate once it is", "database", dbname)
+			go func(dbname string) {
+				<-database.Ready(dbname)
+				migrateDB(dbname)
+			}(dbname)
+		}
+	}
+}
+
+// migrateDB apply pending migrations to master of dbname,
+// schema that can't be migrated isn't served
+func migrateDB(dbname string) {
+	ctx := context.Background()
+
+	db, err := database.Conn(dbname, "master")
+	if err != nil {
+		logger.Fatal(ctx, "fail auto migrate", "database", dbname, "error", err)
+	}
+
+	if _, err := migrate.Up(ctx, db); err != nil {
+		logger.Fatal(ctx, "fail auto migrate", "database", dbname, "error", err)
+	}
+}
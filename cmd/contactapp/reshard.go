@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/contacts"
+	"github.com/ffjabbari/go-microservice-sample/internal/shard"
+)
+
+const (
+	reshardUsage = "usage: contactapp reshard [batch]"
+
+	defaultReshardBatch = 1000
+)
+
+// runReshard run `contactapp reshard` and return exit code
+// it move contacts of every shard that has previous database in config
+func runReshard(args []string) int {
+	batch := defaultReshardBatch
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			fmt.Fprintln(os.Stderr, reshardUsage)
+			return 2
+		}
+		batch = n
+	}
+
+	if !shard.Enabled() || len(shard.Moving()) == 0 {
+		fmt.Println("no shard is being moved")
+		return 0
+	}
+
+	contacts.MapTenants(config.Get().Tenants)
+
+	total := make(map[string]int)
+	err := contacts.Reshard(context.Background(), batch, func(s config.Shard, scanned, moved int) {
+		total[s.Database] += moved
+		fmt.Printf("%s -> %s\tslots %d-%d\tscanned %d\tmoved %d\ttotal %d\n", s.Previous, s.Database, s.From, s.To, scanned, moved, total[s.Database])
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println("done, previous databases can be removed from sharding config")
+	return 0
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+)
+
+// HashAPIKey return the value stored in api_keys.key_hash for a raw key
+// raw keys are never stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func authenticateAPIKey(ctx context.Context, key string) (*Principal, error) {
+	// key is looked up on master, so revoked key stop working right away
+	// rather than after revocation reach the replica
+	dbconn, err := database.Conn(conf.APIKey.Database, "master")
+	if err != nil {
+		return nil, err
+	}
+
+	row := struct {
+		Subject  string `db:"subject"`
+		TenantID string `db:"tenant_id"`
+		Roles    string `db:"roles"`
+	}{}
+
+	err = dbconn.QueryRowxContext(ctx, `
+		SELECT
+			subject, tenant_id, roles
+		FROM
+			api_keys
+		WHERE key_hash = $1
+			AND revoked_at IS NULL
+	`, HashAPIKey(key)).StructScan(&row)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		logger.Error(ctx, "fail lookup api key", "func", "auth.authenticateAPIKey", "error", err)
+		return nil, err
+	}
+
+	// roles is stored as comma separated list
+	roles := []string{}
+	for _, role := range strings.Split(row.Roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	return &Principal{
+		Subject:  row.Subject,
+		TenantID: row.TenantID,
+		Roles:    roles,
+		Method:   "api_key",
+	}, nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+)
+
+type (
+	// Principal is the authenticated caller of a request
+	Principal struct {
+		Subject  string   `json:"subject"`
+		TenantID string   `json:"tenant_id"`
+		Roles    []string `json:"roles"`
+
+		// Method is how the principal is authenticated, "jwt" or "api_key"
+		Method string `json:"method"`
+	}
+
+	ctxKey int
+)
+
+const principalKey ctxKey = iota
+
+var (
+	// ErrNoCredentials is returned when request has no credentials at all
+	ErrNoCredentials = errors.New("no credentials")
+
+	// ErrInvalidCredentials is returned when credentials are present but not valid
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+var conf config.Auth
+
+// Init is for load authentication config and keys
+func Init(authConf config.Auth) error {
+	conf = authConf
+
+	if conf.APIKey.Enabled && conf.APIKey.Database == "" {
+		conf.APIKey.Database = "main"
+	}
+
+	return loadKeys(conf)
+}
+
+// Authenticate is for get principal from request credentials
+func Authenticate(r *http.Request) (*Principal, error) {
+
+	// api key take precedence, it can't be confused with a token
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		if !conf.APIKey.Enabled {
+			return nil, ErrInvalidCredentials
+		}
+		return authenticateAPIKey(r.Context(), key)
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, ErrNoCredentials
+	}
+
+	// only bearer scheme is supported
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return nil, ErrInvalidCredentials
+	}
+
+	return authenticateJWT(strings.TrimSpace(parts[1]))
+}
+
+// WithPrincipal store principal in ctx
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// FromContext return principal stored in ctx
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// HasRole check if principal has the role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var rsaKey *rsa.PrivateKey
+
+func init() {
+	var err error
+	rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	authConf := config.Auth{}
+	authConf.JWT.HMACSecret = "secret"
+	authConf.JWT.Issuers = []string{"test-issuer"}
+	Init(authConf)
+
+	// register rsa key as if it's loaded from jwks file
+	jwksByte := []byte(fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"key1","n":"%s","e":"%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+	))
+	rsaKeys, err = parseJWKS(jwksByte)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func signToken(method jwt.SigningMethod, key interface{}, kid string, c claims) string {
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return tokenString
+}
+
+func TestAuthenticate(t *testing.T) {
+	valid := claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user1",
+			Issuer:    "test-issuer",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		TenantID: "tenant1",
+		Roles:    []string{"editor"},
+	}
+
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	otherIssuer := valid
+	otherIssuer.Issuer = "other-issuer"
+
+	noExpiry := valid
+	noExpiry.ExpiresAt = nil
+
+	testCase := []struct {
+		Header         string
+		ExpectedError  error
+		ExpectedTenant string
+	}{
+		{
+			"Bearer " + signToken(jwt.SigningMethodHS256, []byte("secret"), "", valid),
+			nil,
+			"tenant1",
+		},
+		{
+			"Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "key1", valid),
+			nil,
+			"tenant1",
+		},
+		{
+			"Bearer " + signToken(jwt.SigningMethodRS256, rsaKey, "unknown", valid),
+			ErrInvalidCredentials,
+			"",
+		},
+		{
+			"Bearer " + signToken(jwt.SigningMethodHS256, []byte("wrong secret"), "", valid),
+			ErrInvalidCredentials,
+			"",
+		},
+		{
+			"Bearer " + signToken(jwt.SigningMethodHS256, []byte("secret"), "", expired),
+			ErrInvalidCredentials,
+			"",
+		},
+		{
+			"Bearer " + signToken(jwt.SigningMethodHS256, []byte("secret"), "", otherIssuer),
+			ErrInvalidCredentials,
+			"",
+		},
+		{
+			"Bearer " + signToken(jwt.SigningMethodHS256, []byte("secret"), "", noExpiry),
+			ErrInvalidCredentials,
+			"",
+		},
+		{
+			"Basic dXNlcjpwYXNz",
+			ErrInvalidCredentials,
+			"",
+		},
+		{
+			"",
+			ErrNoCredentials,
+			"",
+		},
+	}
+
+	for index, tcase := range testCase {
+		req := httptest.NewRequest("GET", "http://www.example.com/v1/contacts", nil)
+		if tcase.Header != "" {
+			req.Header.Set("Authorization", tcase.Header)
+		}
+
+		p, err := Authenticate(req)
+		if err != tcase.ExpectedError {
+			t.Errorf("[TestAuthenticate] tcase:%v err got %v | expected %v", index, err, tcase.ExpectedError)
+			continue
+		}
+
+		if err == nil && p.TenantID != tcase.ExpectedTenant {
+			t.Errorf("[TestAuthenticate] tcase:%v tenant got %v | expected %v", index, p.TenantID, tcase.ExpectedTenant)
+		}
+	}
+}
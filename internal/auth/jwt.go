@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type (
+	// claims is the jwt payload we understand
+	claims struct {
+		jwt.RegisteredClaims
+		TenantID string   `json:"tenant_id"`
+		Roles    []string `json:"roles"`
+	}
+
+	jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+)
+
+var hmacSecret []byte
+
+// rsaKeys is public keys from jwks file, keyed by kid
+var rsaKeys map[string]*rsa.PublicKey
+
+var parser = jwt.NewParser(jwt.WithValidMethods([]string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+}))
+
+func loadKeys(conf config.Auth) error {
+	hmacSecret = nil
+	if conf.JWT.HMACSecret != "" {
+		hmacSecret = []byte(conf.JWT.HMACSecret)
+	}
+
+	rsaKeys = make(map[string]*rsa.PublicKey)
+	if conf.JWT.JWKSFile == "" {
+		return nil
+	}
+
+	fileByte, err := ioutil.ReadFile(conf.JWT.JWKSFile)
+	if err != nil {
+		return err
+	}
+
+	rsaKeys, err = parseJWKS(fileByte)
+	return err
+}
+
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set jwks
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		// other key types are not supported, skip it
+		if key.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %s: %v", key.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %s: %v", key.Kid, err)
+		}
+
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// keyFunc choose verification key based on token algorithm and kid
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if hmacSecret == nil {
+			return nil, fmt.Errorf("hmac tokens are not accepted")
+		}
+		return hmacSecret, nil
+
+	case *jwt.SigningMethodRSA:
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := rsaKeys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+
+	return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+}
+
+func authenticateJWT(tokenString string) (*Principal, error) {
+	c := &claims{}
+	_, err := parser.ParseWithClaims(tokenString, c, keyFunc)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	// parser only check exp when it's present, token that never expire isn't accepted
+	if c.ExpiresAt == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	if len(conf.JWT.Issuers) > 0 {
+		valid := false
+		for _, iss := range conf.JWT.Issuers {
+			if c.VerifyIssuer(iss, true) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, ErrInvalidCredentials
+		}
+	}
+
+	if conf.JWT.Audience != "" && !c.VerifyAudience(conf.JWT.Audience, true) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if c.Subject == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{
+		Subject:  c.Subject,
+		TenantID: c.TenantID,
+		Roles:    c.Roles,
+		Method:   "jwt",
+	}, nil
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+)
+
+// breaker states, the value is also the metric value
+const (
+	stateClosed = iota
+	stateHalfOpen
+	stateOpen
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 10 * time.Second
+	defaultTimeout          = 200 * time.Millisecond
+)
+
+type (
+	// breaker stop using redis connection after consecutive failures,
+	// and let it be tried again once open duration has passed
+	breaker struct {
+		sync.Mutex
+		name     string
+		state    int
+		failures int
+		openedAt time.Time
+
+		// probedAt is when the trial of half open breaker was let through
+		probedAt time.Time
+
+		// onClose is called when the breaker is closed after being open
+		onClose func()
+	}
+)
+
+// ErrCircuitOpen is returned by Conn while redis is bypassed
+var ErrCircuitOpen = errors.New("redis circuit open")
+
+var breakerConf config.Breaker
+
+// ConfigureBreaker is for set circuit breaker config, it must be called before ConnectRedis
+func ConfigureBreaker(conf config.Breaker) {
+	breakerConf = conf
+}
+
+func failureThreshold() int {
+	if breakerConf.FailureThreshold > 0 {
+		return breakerConf.FailureThreshold
+	}
+
+	return defaultFailureThreshold
+}
+
+func openDuration() time.Duration {
+	if breakerConf.OpenSeconds > 0 {
+		return time.Duration(breakerConf.OpenSeconds) * time.Second
+	}
+
+	return defaultOpenDuration
+}
+
+func commandTimeout() time.Duration {
+	if breakerConf.TimeoutMs > 0 {
+		return time.Duration(breakerConf.TimeoutMs) * time.Millisecond
+	}
+
+	return defaultTimeout
+}
+
+func newBreaker(name string) *breaker {
+	metrics.CacheBreakerState(name, stateClosed)
+	return &breaker{name: name}
+}
+
+// allow check if connection can be used
+// open breaker becomes half open after open duration and let through a single trial,
+// its recorded result decides whether it's closed or opened again, others are rejected
+// meanwhile, trial that isn't recorded within open duration is given to the next caller
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		if now().Sub(b.openedAt) < openDuration() {
+			return false
+		}
+		b.setState(stateHalfOpen)
+	case stateHalfOpen:
+		if now().Sub(b.probedAt) < openDuration() {
+			return false
+		}
+	default:
+		return true
+	}
+
+	b.probedAt = now()
+	return true
+}
+
+// record result of one redis command
+func (b *breaker) record(err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if !isConnError(err) {
+		b.failures = 0
+		if b.state != stateClosed {
+			b.setState(stateClosed)
+			if b.onClose != nil {
+				b.onClose()
+			}
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= failureThreshold() {
+		b.openedAt = now()
+		b.setState(stateOpen)
+	}
+}
+
+func (b *breaker) stateName() string {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half_open"
+	}
+
+	return "closed"
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	metrics.CacheBreakerState(b.name, state)
+}
+
+// isConnError check if error means redis is unreachable or too slow,
+// error reply and nil reply mean redis is working
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.HasPrefix(msg, "redis: connection pool timeout") ||
+		strings.HasPrefix(msg, "redis: client is closed")
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time {
+		return clock
+	}
+	defer func() {
+		now = time.Now
+	}()
+
+	closed := 0
+	b := newBreaker("test")
+	b.onClose = func() {
+		closed++
+	}
+
+	testCase := []struct {
+		Advance       time.Duration
+		Err           error
+		Repeat        int
+		ExpectedState string
+		ExpectedAllow bool
+	}{
+		// error reply doesn't mean redis is down
+		{0, errors.New("WRONGTYPE"), 10, "closed", true},
+		{0, io.EOF, 4, "closed", true},
+		{0, io.EOF, 1, "open", false},
+		{5 * time.Second, nil, 0, "open", false},
+		// the first result after open duration decides
+		{5 * time.Second, io.EOF, 1, "open", false},
+		{10 * time.Second, nil, 1, "closed", true},
+	}
+
+	for index, tcase := range testCase {
+		clock = clock.Add(tcase.Advance)
+
+		for i := 0; i < tcase.Repeat; i++ {
+			if !b.allow() {
+				break
+			}
+			b.record(tcase.Err)
+		}
+
+		if state := b.stateName(); state != tcase.ExpectedState {
+			t.Errorf("[TestBreaker] tcase:%v state got %v | expected %v", index, state, tcase.ExpectedState)
+		}
+
+		if allow := b.allow(); allow != tcase.ExpectedAllow {
+			t.Errorf("[TestBreaker] tcase:%v allow got %v | expected %v", index, allow, tcase.ExpectedAllow)
+		}
+	}
+
+	if closed != 1 {
+		t.Errorf("[TestBreaker] close callback got %v | expected 1", closed)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time {
+		return clock
+	}
+	defer func() {
+		now = time.Now
+	}()
+
+	b := newBreaker("test")
+	for i := 0; i < defaultFailureThreshold; i++ {
+		b.record(io.EOF)
+	}
+
+	testCase := []struct {
+		Advance       time.Duration
+		Trial         bool
+		Record        bool
+		Err           error
+		ExpectedAllow []bool
+		ExpectedState string
+	}{
+		// only the first caller after open duration is the trial
+		{defaultOpenDuration, false, false, nil, []bool{true, false, false}, "half_open"},
+		// trial that never recorded is given to another caller
+		{defaultOpenDuration / 2, false, false, nil, []bool{false}, "half_open"},
+		{defaultOpenDuration / 2, false, false, nil, []bool{true, false}, "half_open"},
+		// failed trial open it again
+		{0, false, true, io.EOF, []bool{false}, "open"},
+		{defaultOpenDuration, true, true, nil, []bool{true, true}, "closed"},
+	}
+
+	for index, tcase := range testCase {
+		clock = clock.Add(tcase.Advance)
+
+		if tcase.Trial && !b.allow() {
+			t.Errorf("[TestBreakerHalfOpen] tcase:%v trial got rejected | expected allowed", index)
+		}
+
+		if tcase.Record {
+			b.record(tcase.Err)
+		}
+
+		for i, expected := range tcase.ExpectedAllow {
+			if allow := b.allow(); allow != expected {
+				t.Errorf("[TestBreakerHalfOpen] tcase:%v call:%v allow got %v | expected %v", index, i, allow, expected)
+			}
+		}
+
+		if state := b.stateName(); state != tcase.ExpectedState {
+			t.Errorf("[TestBreakerHalfOpen] tcase:%v state got %v | expected %v", index, state, tcase.ExpectedState)
+		}
+	}
+}
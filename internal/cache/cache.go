@@ -1,33 +1,177 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"sync"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
 
 	"gopkg.in/redis.v5"
 )
 
-var connections map[string]*redis.Client
+type (
+	// processWrapper is implemented by clients that let every command be observed
+	processWrapper interface {
+		WrapProcess(func(func(cmd redis.Cmder) error) func(cmd redis.Cmder) error)
+	}
+)
+
+var connections map[string]redis.Cmdable
+var breakers map[string]*breaker
+
+// pending are keys that couldn't be deleted while redis was unavailable, grouped by connection name
+// they're deleted once the breaker of the connection is closed again
+var pending = make(map[string]map[string]bool)
+var pendingLock sync.Mutex
+
+// maxPending is the most keys remembered per connection
+const maxPending = 10000
 
 // ConnectRedis for initiate connection to redis server, and store it as private global variable
-func ConnectRedis(config map[string]string) {
+func ConnectRedis(config map[string]config.Redis) {
 
-	connections = make(map[string]*redis.Client)
+	connections = make(map[string]redis.Cmdable)
+	breakers = make(map[string]*breaker)
 
-	for name, addr := range config {
-		conn := redis.NewClient(&redis.Options{
-			Addr:     addr,
-			Password: "",
-		})
+	for name, conf := range config {
+		conn, err := newClient(conf)
+		if err != nil {
+			logger.Fatal(context.Background(), "fail connect to redis", "cache", name, "error", err)
+		}
+
+		// every command result is recorded by the breaker of its connection
+		b := newBreaker(name)
+		b.onClose = flushPending(name)
+		if w, ok := conn.(processWrapper); ok {
+			w.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+				return func(cmd redis.Cmder) error {
+					err := process(cmd)
+					b.record(err)
+					return err
+				}
+			})
+		}
 
 		connections[name] = conn
+		breakers[name] = b
 	}
 }
 
-// Conn is for get redis connection
-func Conn(name string) (*redis.Client, error) {
-	if rds, ok := connections[name]; ok {
-		return rds, nil
+// Conn is for get redis connection, it's single node, sentinel or cluster client
+// ErrCircuitOpen is returned while redis is bypassed after failures
+func Conn(name string) (redis.Cmdable, error) {
+	rds, ok := connections[name]
+	if !ok {
+		return nil, fmt.Errorf("redis conn not found")
+	}
+
+	if !breakers[name].allow() {
+		return nil, ErrCircuitOpen
 	}
 
-	return nil, fmt.Errorf("redis conn not found")
+	return rds, nil
+}
+
+// Exists check if redis connection of name is configured
+func Exists(name string) bool {
+	_, ok := connections[name]
+	return ok
+}
+
+// Subscribe listen to channels of a connection, cluster connection doesn't support it
+func Subscribe(name string, channels ...string) (*redis.PubSub, error) {
+	client, err := pubsubClient(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Subscribe(channels...)
+}
+
+// Publish send message to channel of a connection, cluster connection doesn't support it
+func Publish(name, channel, message string) error {
+	client, err := pubsubClient(name)
+	if err != nil {
+		return err
+	}
+
+	return client.Publish(channel, message).Err()
+}
+
+// SupportsPubSub check if connection can be used for Subscribe and Publish
+func SupportsPubSub(name string) bool {
+	_, ok := connections[name].(*redis.Client)
+	return ok
+}
+
+func pubsubClient(name string) (*redis.Client, error) {
+	rds, err := Conn(name)
+	if err != nil {
+		return nil, err
+	}
+
+	client, ok := rds.(*redis.Client)
+	if !ok {
+		return nil, fmt.Errorf("redis conn %v doesn't support pub/sub", name)
+	}
+
+	return client, nil
+}
+
+// Health return breaker state of every connection, keyed by connection name
+func Health() map[string]string {
+	states := make(map[string]string)
+	for name, b := range breakers {
+		states[name] = b.stateName()
+	}
+
+	return states
+}
+
+// Invalidate delete keys, if redis is unavailable the keys are remembered
+// and deleted when it's reachable again, so stale entries don't outlive the outage
+func Invalidate(name string, keys ...string) error {
+	rds, err := Conn(name)
+	if err == nil {
+		err = rds.Del(keys...).Err()
+	}
+	if err == nil {
+		return nil
+	}
+
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+
+	if pending[name] == nil {
+		pending[name] = make(map[string]bool)
+	}
+
+	for _, key := range keys {
+		if len(pending[name]) >= maxPending {
+			logger.Warn(context.Background(), "too many pending invalidation, key is dropped", "func", "cache.Invalidate", "cache", name, "key", key)
+			continue
+		}
+		pending[name][key] = true
+	}
+
+	return err
+}
+
+// flushPending return function that delete remembered keys of a connection
+func flushPending(name string) func() {
+	return func() {
+		pendingLock.Lock()
+		keys := make([]string, 0, len(pending[name]))
+		for key := range pending[name] {
+			keys = append(keys, key)
+		}
+		delete(pending, name)
+		pendingLock.Unlock()
+
+		if len(keys) != 0 {
+			go Invalidate(name, keys...)
+		}
+	}
 }
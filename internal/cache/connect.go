@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+
+	"gopkg.in/redis.v5"
+)
+
+// newClient create single node, sentinel or cluster client from config
+func newClient(conf config.Redis) (redis.Cmdable, error) {
+	poolTimeout := time.Duration(conf.PoolTimeoutMs) * time.Millisecond
+	idleTimeout := time.Duration(conf.IdleTimeoutSeconds) * time.Second
+
+	switch {
+	case conf.Addr != "":
+		opt := &redis.Options{
+			Addr:         conf.Addr,
+			Password:     conf.Password,
+			DB:           conf.DB,
+			PoolSize:     conf.PoolSize,
+			PoolTimeout:  poolTimeout,
+			IdleTimeout:  idleTimeout,
+			DialTimeout:  commandTimeout(),
+			ReadTimeout:  commandTimeout(),
+			WriteTimeout: commandTimeout(),
+		}
+
+		if conf.TLS.Enabled || conf.Username != "" {
+			tlsConf, err := tlsConfig(conf)
+			if err != nil {
+				return nil, err
+			}
+
+			// the client only send AUTH password, so ACL user is authenticated by the dialer
+			if conf.Username != "" {
+				opt.Password = ""
+			}
+			opt.Dialer = dialer(conf, tlsConf)
+		}
+
+		return redis.NewClient(opt), nil
+
+	case len(conf.Sentinel.Addrs) != 0:
+		if conf.TLS.Enabled || conf.Username != "" {
+			return nil, errors.New("tls and acl user aren't supported with sentinel")
+		}
+
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    conf.Sentinel.MasterName,
+			SentinelAddrs: conf.Sentinel.Addrs,
+			Password:      conf.Password,
+			DB:            conf.DB,
+			PoolSize:      conf.PoolSize,
+			PoolTimeout:   poolTimeout,
+			IdleTimeout:   idleTimeout,
+			DialTimeout:   commandTimeout(),
+			ReadTimeout:   commandTimeout(),
+			WriteTimeout:  commandTimeout(),
+		}), nil
+
+	case len(conf.Cluster.Addrs) != 0:
+		if conf.TLS.Enabled || conf.Username != "" {
+			return nil, errors.New("tls and acl user aren't supported with cluster")
+		}
+		if conf.DB != 0 {
+			return nil, errors.New("cluster only has db 0")
+		}
+
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        conf.Cluster.Addrs,
+			Password:     conf.Password,
+			PoolSize:     conf.PoolSize,
+			PoolTimeout:  poolTimeout,
+			IdleTimeout:  idleTimeout,
+			DialTimeout:  commandTimeout(),
+			ReadTimeout:  commandTimeout(),
+			WriteTimeout: commandTimeout(),
+		}), nil
+	}
+
+	return nil, errors.New("redis addr, sentinel or cluster is required")
+}
+
+// tlsConfig return nil when tls is disabled
+func tlsConfig(conf config.Redis) (*tls.Config, error) {
+	if !conf.TLS.Enabled {
+		return nil, nil
+	}
+
+	tlsConf := &tls.Config{
+		ServerName:         conf.TLS.ServerName,
+		InsecureSkipVerify: conf.TLS.InsecureSkipVerify,
+	}
+
+	if tlsConf.ServerName == "" {
+		tlsConf.ServerName, _, _ = net.SplitHostPort(conf.Addr)
+	}
+
+	if conf.TLS.CAFile != "" {
+		caByte, err := ioutil.ReadFile(conf.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(caByte) {
+			return nil, fmt.Errorf("no certificate found in %v", conf.TLS.CAFile)
+		}
+	}
+
+	if conf.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.TLS.CertFile, conf.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
+}
+
+// dialer open connection to single node, over tls when tlsConf isn't nil,
+// and authenticate ACL user before the connection is handed to the client
+func dialer(conf config.Redis, tlsConf *tls.Config) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		netDialer := &net.Dialer{
+			Timeout:   commandTimeout(),
+			KeepAlive: 5 * time.Minute,
+		}
+
+		var conn net.Conn
+		var err error
+		if tlsConf != nil {
+			conn, err = tls.DialWithDialer(netDialer, "tcp", conf.Addr, tlsConf)
+		} else {
+			conn, err = netDialer.Dial("tcp", conf.Addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if conf.Username != "" {
+			err = authenticate(conn, conf.Username, conf.Password)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+
+		return conn, nil
+	}
+}
+
+// authenticate send AUTH username password, which the client of redis.v5 doesn't know
+func authenticate(conn net.Conn, username, password string) error {
+	conn.SetDeadline(time.Now().Add(commandTimeout()))
+	defer conn.SetDeadline(time.Time{})
+
+	_, err := fmt.Fprintf(conn, "*3\r\n$4\r\nAUTH\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+		len(username), username, len(password), password)
+	if err != nil {
+		return err
+	}
+
+	// nothing else is sent by redis until the next command, so buffered reader doesn't lose data
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(reply, "+OK") {
+		return fmt.Errorf("redis auth failed: %v", strings.TrimSpace(reply))
+	}
+
+	return nil
+}
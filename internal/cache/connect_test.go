@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	testCase := []struct {
+		Reply       string
+		ExpectError bool
+	}{
+		{"+OK\r\n", false},
+		{"-WRONGPASS invalid username-password pair\r\n", true},
+	}
+
+	for index, tcase := range testCase {
+		client, server := net.Pipe()
+
+		received := make(chan string, 1)
+		go func() {
+			// AUTH username password is 7 lines
+			r := bufio.NewReader(server)
+			cmd := ""
+			for i := 0; i < 7; i++ {
+				line, _ := r.ReadString('\n')
+				cmd += line
+			}
+			received <- cmd
+			server.Write([]byte(tcase.Reply))
+		}()
+
+		err := authenticate(client, "app", "secret")
+		if (err != nil) != tcase.ExpectError {
+			t.Errorf("[TestAuthenticate] tcase:%v err got %v | expected err!=nil->%v", index, err, tcase.ExpectError)
+		}
+
+		expected := "*3\r\n$4\r\nAUTH\r\n$3\r\napp\r\n$6\r\nsecret\r\n"
+		if cmd := <-received; cmd != expected {
+			t.Errorf("[TestAuthenticate] tcase:%v command got %q | expected %q", index, cmd, expected)
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+)
+
+type (
+	// LRU is in-process cache that keep at most size entries for at most ttl,
+	// least recently used entry is evicted first
+	LRU struct {
+		sync.Mutex
+		name  string
+		size  int
+		ttl   time.Duration
+		items map[string]*list.Element
+		order *list.List
+	}
+
+	lruEntry struct {
+		key       string
+		value     interface{}
+		expiresAt time.Time
+	}
+)
+
+// now is replaced in unit test
+var now = time.Now
+
+// NewLRU create in-process cache, name is used as metric label
+func NewLRU(name string, size int, ttl time.Duration) *LRU {
+	return &LRU{
+		name:  name,
+		size:  size,
+		ttl:   ttl,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Get return value of key, expired entry is treated as missing
+func (l *LRU) Get(key string) (interface{}, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	elem, ok := l.items[key]
+	if !ok {
+		metrics.CacheMiss(l.name)
+		return nil, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && now().After(entry.expiresAt) {
+		l.remove(elem, "expired")
+		metrics.CacheMiss(l.name)
+		return nil, false
+	}
+
+	l.order.MoveToFront(elem)
+	metrics.CacheHit(l.name)
+
+	return entry.value, true
+}
+
+// Set store value of key, evicting least recently used entry when it's full
+func (l *LRU) Set(key string, value interface{}) {
+	l.SetWithTTL(key, value, l.ttl)
+}
+
+// SetWithTTL store value of key with its own ttl, zero ttl never expires
+func (l *LRU) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = now().Add(ttl)
+	}
+
+	if elem, ok := l.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		l.order.MoveToFront(elem)
+		return
+	}
+
+	l.items[key] = l.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back(), "size")
+	}
+
+	metrics.CacheEntries(l.name, l.order.Len())
+}
+
+// Remove drop key, it's used when the value is changed somewhere else
+func (l *LRU) Remove(key string) {
+	l.Lock()
+	defer l.Unlock()
+
+	if elem, ok := l.items[key]; ok {
+		l.remove(elem, "invalidated")
+	}
+}
+
+// Purge drop every entry
+func (l *LRU) Purge() {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, elem := range l.items {
+		l.remove(elem, "invalidated")
+	}
+}
+
+// Len return number of entries, including expired ones that aren't removed yet
+func (l *LRU) Len() int {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.order.Len()
+}
+
+func (l *LRU) remove(elem *list.Element, reason string) {
+	entry := l.order.Remove(elem).(*lruEntry)
+	delete(l.items, entry.key)
+
+	metrics.CacheEviction(l.name, reason)
+	metrics.CacheEntries(l.name, l.order.Len())
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	clock := time.Unix(1500000000, 0)
+	now = func() time.Time {
+		return clock
+	}
+	defer func() {
+		now = time.Now
+	}()
+
+	lru := NewLRU("test", 2, 10*time.Second)
+
+	testCase := []struct {
+		Set      string
+		Get      string
+		Remove   string
+		Advance  time.Duration
+		Expected bool
+	}{
+		{"a", "a", "", 0, true},
+		{"b", "a", "", 0, true},
+		// "b" is least recently used, so it's evicted
+		{"c", "b", "", 0, false},
+		{"", "a", "", 0, true},
+		{"", "c", "c", 0, false},
+		{"", "a", "", 11 * time.Second, false},
+	}
+
+	for index, tcase := range testCase {
+		clock = clock.Add(tcase.Advance)
+
+		if tcase.Set != "" {
+			lru.Set(tcase.Set, tcase.Set)
+		}
+		if tcase.Remove != "" {
+			lru.Remove(tcase.Remove)
+		}
+
+		val, ok := lru.Get(tcase.Get)
+		if ok != tcase.Expected {
+			t.Errorf("[TestLRU] tcase:%v found got %v | expected %v", index, ok, tcase.Expected)
+		}
+
+		if ok && val != tcase.Get {
+			t.Errorf("[TestLRU] tcase:%v value got %v | expected %v", index, val, tcase.Get)
+		}
+	}
+
+	if lru.Len() != 0 {
+		t.Errorf("[TestLRU] len got %v | expected 0", lru.Len())
+	}
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+type (
+	// Store is key value cache of serialized objects
+	// zero ttl means the value never expires
+	Store interface {
+		// Get return ErrMiss when key isn't cached
+		Get(key string) ([]byte, error)
+		Set(key string, value []byte, ttl time.Duration) error
+		Del(keys ...string) error
+	}
+
+	// redisStore keep values in named redis connection
+	redisStore struct {
+		name string
+	}
+
+	// memoryStore keep values in process, it's only coherent with one instance
+	memoryStore struct {
+		lru *LRU
+	}
+
+	// noopStore never keep anything, every Get is a miss
+	noopStore struct{}
+)
+
+// ErrMiss is returned by Store.Get when key isn't cached
+var ErrMiss = errors.New("cache miss")
+
+// NewRedisStore return Store backed by named redis connection
+// it goes through Conn, so it's bypassed while the breaker is open
+func NewRedisStore(name string) Store {
+	return &redisStore{name: name}
+}
+
+// NewMemoryStore return in-process Store that keep at most size values
+func NewMemoryStore(name string, size int) Store {
+	return &memoryStore{lru: NewLRU(name, size, 0)}
+}
+
+// NewNoopStore return Store that disable caching
+func NewNoopStore() Store {
+	return noopStore{}
+}
+
+func (rs *redisStore) Get(key string) ([]byte, error) {
+	rds, err := Conn(rs.name)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := rds.Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+
+	return value, err
+}
+
+func (rs *redisStore) Set(key string, value []byte, ttl time.Duration) error {
+	rds, err := Conn(rs.name)
+	if err != nil {
+		return err
+	}
+
+	return rds.Set(key, value, ttl).Err()
+}
+
+// Del remember keys that can't be deleted, see Invalidate
+func (rs *redisStore) Del(keys ...string) error {
+	return Invalidate(rs.name, keys...)
+}
+
+func (ms *memoryStore) Get(key string) ([]byte, error) {
+	value, ok := ms.lru.Get(key)
+	if !ok {
+		return nil, ErrMiss
+	}
+
+	return value.([]byte), nil
+}
+
+func (ms *memoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	ms.lru.SetWithTTL(key, value, ttl)
+	return nil
+}
+
+func (ms *memoryStore) Del(keys ...string) error {
+	for _, key := range keys {
+		ms.lru.Remove(key)
+	}
+
+	return nil
+}
+
+func (noopStore) Get(key string) ([]byte, error) {
+	return nil, ErrMiss
+}
+
+func (noopStore) Set(key string, value []byte, ttl time.Duration) error {
+	return nil
+}
+
+func (noopStore) Del(keys ...string) error {
+	return nil
+}
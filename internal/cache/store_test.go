@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	testCase := []struct {
+		Store         Store
+		ExpectedValue string
+	}{
+		{NewMemoryStore("test_memory", 10), "value"},
+		{NewNoopStore(), ""},
+	}
+
+	for index, tcase := range testCase {
+		tcase.Store.Set("key", []byte("value"), time.Minute)
+
+		value, err := tcase.Store.Get("key")
+		if string(value) != tcase.ExpectedValue {
+			t.Errorf("[TestStore] tcase:%v value got %s | expected %v", index, value, tcase.ExpectedValue)
+		}
+		if tcase.ExpectedValue == "" && err != ErrMiss {
+			t.Errorf("[TestStore] tcase:%v err got %v | expected %v", index, err, ErrMiss)
+		}
+
+		tcase.Store.Del("key")
+		if _, err := tcase.Store.Get("key"); err != ErrMiss {
+			t.Errorf("[TestStore] tcase:%v err after del got %v | expected %v", index, err, ErrMiss)
+		}
+	}
+}
@@ -9,24 +9,309 @@ import (
 type (
 	// C is stand for config object
 	C struct {
-		Database map[string]*struct {
-			Master string
-			Slave  string
-		} `json:"database"`
+		Database map[string]*Database `json:"database"`
 
-		Redis map[string]string `json:"redis"`
+		// Redis is named redis connection, value is either address or Redis object
+		Redis map[string]Redis `json:"redis"`
+
+		// RedisBreaker is applied to every redis connection
+		RedisBreaker Breaker `json:"redis_breaker"`
+
+		// Tenants maps tenant id to named database in Database
+		// tenant that isn't listed is stored in "main"
+		Tenants map[string]string `json:"tenants"`
+
+		// Sharding spread contacts of tenants that aren't listed in Tenants over
+		// several named databases by contact id
+		Sharding Sharding `json:"sharding"`
+
+		IDGen IDGen `json:"id_generator"`
 
 		Port string `json:"port"`
+
+		Tracing Tracing `json:"tracing"`
+
+		Log Log `json:"log"`
+
+		Auth Auth `json:"auth"`
+
+		RateLimit RateLimit `json:"rate_limit"`
+
+		Idempotency Idempotency `json:"idempotency"`
+
+		ContactCache ContactCache `json:"contact_cache"`
+
+		Migrate Migrate `json:"migrate"`
+
+		Consistency Consistency `json:"consistency"`
+	}
+
+	// Sharding is config for hash based sharding of contacts, it's disabled without Shards
+	// contact id is hashed to one of Slots, and every slot belongs to one shard
+	Sharding struct {
+		// Slots must not change once contacts are stored, default is 256
+		Slots int `json:"slots"`
+
+		// Shards must cover every slot exactly once
+		Shards []Shard `json:"shards"`
 	}
 
-	dbconf struct {
+	// Shard is range of slots stored in one named database
+	Shard struct {
+		Database string `json:"database"`
+
+		// From and To are the first and the last slot, inclusive
+		From int `json:"from"`
+		To   int `json:"to"`
+
+		// Previous is the database these slots are moved from,
+		// it's still read until `contactapp reshard` has moved every contact
+		Previous string `json:"previous"`
+	}
+
+	// IDGen is config for generating contact ids
+	// Node must be unique among running instances, from 0 to 1023, without it node
+	// is read from CONTACTAPP_NODE env or ordinal of hostname like contactapp-3
+	IDGen struct {
+		Node *int64 `json:"node"`
+	}
+
+	// Consistency is config for read-your-writes token sent to clients
+	// token is signed with Secret, or content of SecretFile when Secret is empty
+	Consistency struct {
+		Secret     string `json:"secret"`
+		SecretFile string `json:"secret_file"`
+	}
+
+	// Migrate is config for schema migrations
+	// Auto apply pending migrations to every database on start
+	Migrate struct {
+		Auto bool `json:"auto"`
+	}
+
+	// Database is config of one named database, reads are spread over its replicas
+	Database struct {
 		Master string `json:"master"`
-		Slave  string `json:"slave"`
+
+		// Slave is single replica, it's kept for older config and used as first of Slaves
+		Slave  string   `json:"slave"`
+		Slaves []string `json:"slaves"`
+
+		// Balancer is "round_robin" or "least_conn", default is round_robin
+		Balancer string `json:"balancer"`
+
+		// HealthCheckSeconds is how often replicas are pinged and their lag measured,
+		// failing replica isn't used until it answers again, default is 5
+		HealthCheckSeconds int64 `json:"health_check_seconds"`
+
+		// MaxLagMs is replication lag above which replica isn't read, 0 means no limit
+		// reads go to master when every replica lag behind
+		MaxLagMs int64 `json:"max_lag_ms"`
+
+		// Pool is applied to master and every replica
+		Pool Pool `json:"pool"`
+
+		// ConnectTimeoutSeconds bound dialing of one connection, 0 means no limit
+		ConnectTimeoutSeconds int64 `json:"connect_timeout_seconds"`
+
+		// Lazy start without waiting for the database, it's retried with backoff in background
+		// otherwise start fail after a few retries
+		Lazy bool `json:"lazy"`
+	}
+
+	// Pool is config of database connection pool, zero value keep the default
+	Pool struct {
+		// MaxOpenConns default is 10, MaxIdleConns default is 3
+		MaxOpenConns int `json:"max_open_conns"`
+		MaxIdleConns int `json:"max_idle_conns"`
+
+		// ConnMaxLifetimeSeconds and ConnMaxIdleTimeSeconds close old connections, 0 means never
+		ConnMaxLifetimeSeconds int64 `json:"conn_max_lifetime_seconds"`
+		ConnMaxIdleTimeSeconds int64 `json:"conn_max_idle_time_seconds"`
+	}
+
+	// Redis is config of one redis connection
+	// exactly one of Addr, Sentinel or Cluster is used, in that order of priority
+	Redis struct {
+		Addr string `json:"addr"`
+
+		// Username is ACL user of redis 6+, it's only supported by single node
+		Username string `json:"username"`
+		Password string `json:"password"`
+
+		// DB is database index, cluster only has database 0
+		DB int `json:"db"`
+
+		PoolSize           int   `json:"pool_size"`
+		PoolTimeoutMs      int64 `json:"pool_timeout_ms"`
+		IdleTimeoutSeconds int64 `json:"idle_timeout_seconds"`
+
+		// TLS is only supported by single node
+		TLS struct {
+			Enabled bool `json:"enabled"`
+
+			// CAFile is PEM file of CA that signs server certificate, empty means system roots
+			CAFile string `json:"ca_file"`
+
+			// CertFile and KeyFile are client certificate for mutual TLS
+			CertFile string `json:"cert_file"`
+			KeyFile  string `json:"key_file"`
+
+			ServerName         string `json:"server_name"`
+			InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+		} `json:"tls"`
+
+		Sentinel struct {
+			MasterName string   `json:"master_name"`
+			Addrs      []string `json:"addrs"`
+		} `json:"sentinel"`
+
+		Cluster struct {
+			Addrs []string `json:"addrs"`
+		} `json:"cluster"`
+	}
+
+	// Breaker is config for circuit breaker around redis connection
+	Breaker struct {
+		// FailureThreshold is consecutive failures that open the breaker
+		FailureThreshold int `json:"failure_threshold"`
+
+		// OpenSeconds is how long redis is bypassed before it's tried again
+		OpenSeconds int64 `json:"open_seconds"`
+
+		// TimeoutMs is read and write timeout of redis commands
+		TimeoutMs int64 `json:"timeout_ms"`
+	}
+
+	// ContactCache is config for redis cache of contacts
+	ContactCache struct {
+		// Store is "redis", "memory" or "none", memory is only coherent with one instance
+		Store string `json:"store"`
+
+		// MemorySize is the most entries kept by memory store
+		MemorySize int `json:"memory_size"`
+
+		// ContactTTLSeconds is expiry of single contact entry
+		ContactTTLSeconds int64 `json:"contact_ttl_seconds"`
+
+		// ListTTLSeconds is expiry of cached list page
+		ListTTLSeconds int64 `json:"list_ttl_seconds"`
+
+		// NotFoundTTLSeconds is expiry of cached not found id
+		NotFoundTTLSeconds int64 `json:"not_found_ttl_seconds"`
+
+		// EarlyRefreshBeta tune how early cached contact is refreshed before expiry,
+		// higher is earlier, 0 means 1
+		EarlyRefreshBeta float64 `json:"early_refresh_beta"`
+
+		// Local is in-process cache in front of redis, kept coherent
+		// across instances through redis pub/sub
+		Local struct {
+			Enabled    bool  `json:"enabled"`
+			Size       int   `json:"size"`
+			TTLSeconds int64 `json:"ttl_seconds"`
+		} `json:"local"`
+	}
+
+	// Idempotency is config for Idempotency-Key support on mutating routes
+	Idempotency struct {
+		Enabled bool `json:"enabled"`
+
+		// Cache is the named redis connection that holds stored responses
+		Cache string `json:"cache"`
+
+		// TTLSeconds is how long a response is replayed for the same key
+		TTLSeconds int64 `json:"ttl_seconds"`
+
+		// LockSeconds is how long a key stays in progress if the request never finish
+		LockSeconds int64 `json:"lock_seconds"`
+	}
+
+	// RateLimit is config for per client and per route request limit
+	RateLimit struct {
+		Enabled bool `json:"enabled"`
+
+		// Cache is the named redis connection that holds the buckets
+		Cache string `json:"cache"`
+
+		// Client is applied to every request of one client across all routes
+		Client Limit `json:"client"`
+
+		// Routes is applied per client on top of Client, keyed by "METHOD /route/pattern"
+		Routes map[string]Limit `json:"routes"`
+
+		// Anonymous is applied per ip to requests that fail authentication,
+		// ip that ran out of it is rejected before it's authenticated
+		Anonymous Limit `json:"anonymous"`
+	}
+
+	// Limit allow Requests per Seconds, with burst up to Requests
+	Limit struct {
+		Requests int64 `json:"requests"`
+		Seconds  int64 `json:"seconds"`
+	}
+
+	// Auth is config for api authentication
+	// JWT is accepted from Authorization: Bearer header, api key from X-API-Key header
+	Auth struct {
+		JWT struct {
+			// HMACSecret is used to verify HS256/HS384/HS512 tokens
+			HMACSecret string `json:"hmac_secret"`
+
+			// JWKSFile is path of json web key set used to verify RS256/RS384/RS512 tokens
+			JWKSFile string `json:"jwks_file"`
+
+			// Issuers is list of accepted iss claim, empty means any issuer
+			Issuers []string `json:"issuers"`
+
+			// Audience is the required aud claim, empty means no check
+			Audience string `json:"audience"`
+		} `json:"jwt"`
+
+		APIKey struct {
+			Enabled bool `json:"enabled"`
+
+			// Database is the named database that holds api_keys table
+			Database string `json:"database"`
+		} `json:"api_key"`
+	}
+
+	// Log is config for structured logger
+	// Level is one of debug, info, warn, error
+	// Format is json or text, Sinks can be stdout, stderr or file path
+	Log struct {
+		Level  string   `json:"level"`
+		Format string   `json:"format"`
+		Sinks  []string `json:"sinks"`
+	}
+
+	// Tracing is config for distributed tracing
+	// Exporter is one of "otlp", "stdout", "file" or empty to disable tracing
+	Tracing struct {
+		Exporter    string  `json:"exporter"`
+		Endpoint    string  `json:"endpoint"`
+		Insecure    bool    `json:"insecure"`
+		File        string  `json:"file"`
+		ServiceName string  `json:"service_name"`
+		SampleRatio float64 `json:"sample_ratio"`
 	}
 )
 
 var configuration C
 
+// UnmarshalJSON accept plain address string as well as Redis object
+func (r *Redis) UnmarshalJSON(data []byte) error {
+	var addr string
+	if err := json.Unmarshal(data, &addr); err == nil {
+		*r = Redis{Addr: addr}
+		return nil
+	}
+
+	// alias doesn't have UnmarshalJSON, so it's decoded field by field
+	type redisAlias Redis
+	return json.Unmarshal(data, (*redisAlias)(r))
+}
+
 // ReadConfig is for read configuration from multiple path
 // it'll loop all filepath and stop if file is found
 func ReadConfig(filepath ...string) *C {
@@ -0,0 +1,306 @@
+package contacts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/auth"
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/idgen"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+	"github.com/ffjabbari/go-microservice-sample/internal/tracing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// roles of a principal
+const (
+	// RoleViewer can read own contacts and contacts shared to it
+	RoleViewer = "viewer"
+
+	// RoleEditor can also create contacts and modify contacts it owns or that are shared with write permission
+	RoleEditor = "editor"
+
+	// RoleAdmin can read and modify every contact of its tenant
+	RoleAdmin = "admin"
+)
+
+// permissions of a share
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+// access level of a principal to one contact, from lowest to highest
+const (
+	accessNone = iota
+	accessRead
+	accessWrite
+	accessOwner
+)
+
+type (
+	// Share is a grant of one contact to another principal of the same tenant
+	Share struct {
+		Grantee    string `json:"grantee" db:"grantee"`
+		Permission string `json:"permission" db:"permission"`
+	}
+
+	// shareEvent is outbox payload of grant change, permission is empty when it's revoked
+	shareEvent struct {
+		ContactID string `json:"contact_id"`
+		Share
+	}
+)
+
+// ErrForbidden is returned when principal is not allowed to do the operation
+var ErrForbidden = errors.New("forbidden")
+
+// ErrInvalidShare is returned when share has no grantee or unknown permission
+var ErrInvalidShare = errors.New("invalid share")
+
+func principalFromContext(ctx context.Context) (*auth.Principal, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	return p, nil
+}
+
+// canEdit check if role of principal allow any modification
+func canEdit(p *auth.Principal) bool {
+	return p.HasRole(RoleEditor) || p.HasRole(RoleAdmin)
+}
+
+// access return access level of principal in ctx to the contact
+func (c *contact) access(ctx context.Context) (int, error) {
+	p, err := principalFromContext(ctx)
+	if err != nil {
+		return accessNone, err
+	}
+
+	level := accessNone
+	switch {
+	case p.HasRole(RoleAdmin), c.data.OwnerID == p.Subject:
+		level = accessOwner
+
+	default:
+		level, err = c.shareAccess(ctx, p.Subject)
+		if err != nil {
+			return accessNone, err
+		}
+	}
+
+	// viewer only ever read, whatever it's granted
+	if level > accessRead && !canEdit(p) {
+		level = accessRead
+	}
+
+	return level, nil
+}
+
+// shareAccess return access level granted to grantee through contact_shares
+func (c *contact) shareAccess(ctx context.Context, grantee string) (int, error) {
+	dbname, err := c.home(ctx)
+	if err != nil {
+		return accessNone, err
+	}
+
+	dbconn, err := database.Queryer(ctx, dbname, "slave")
+	if err != nil {
+		return accessNone, err
+	}
+
+	var permission string
+	err = dbconn.QueryRowxContext(ctx, `
+		SELECT
+			permission
+		FROM
+			contact_shares
+		WHERE tenant_id = $1
+			AND contact_id = $2
+			AND grantee = $3
+	`, c.tenantID, c.data.ID, grantee).Scan(&permission)
+	if err == sql.ErrNoRows {
+		return accessNone, nil
+	}
+	if err != nil {
+		logger.Error(ctx, "fail get share", "func", "contacts.shareAccess", "contact_id", c.data.ID, "error", err)
+		return accessNone, err
+	}
+
+	if permission == PermissionWrite {
+		return accessWrite, nil
+	}
+
+	return accessRead, nil
+}
+
+// Shares return all grants of the contact, only owner and admin can see it
+func (c *contact) Shares(ctx context.Context) ([]Share, error) {
+	level, err := c.access(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if level < accessOwner {
+		return nil, ErrForbidden
+	}
+
+	dbname, err := c.home(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dbconn, err := database.Queryer(ctx, dbname, "slave")
+	if err != nil {
+		return nil, err
+	}
+
+	shares := []Share{}
+	err = sqlx.SelectContext(ctx, dbconn, &shares, `
+		SELECT
+			grantee, permission
+		FROM
+			contact_shares
+		WHERE tenant_id = $1
+			AND contact_id = $2
+		ORDER BY grantee ASC
+	`, c.tenantID, c.data.ID)
+	if err != nil {
+		logger.Error(ctx, "fail list shares", "func", "contacts.Shares", "contact_id", c.data.ID, "error", err)
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+// Share grant the contact to grantee, existing grant is replaced
+func (c *contact) Share(ctx context.Context, share Share) error {
+	if share.Grantee == "" || (share.Permission != PermissionRead && share.Permission != PermissionWrite) {
+		return ErrInvalidShare
+	}
+
+	level, err := c.access(ctx)
+	if err != nil {
+		return err
+	}
+	if level < accessOwner {
+		return ErrForbidden
+	}
+
+	err = c.onHome(ctx, func(ctx context.Context, dbname string) error {
+		return c.share(ctx, dbname, share)
+	})
+	if err != nil && err != ErrNotFound {
+		logger.Error(ctx, "fail store share", "func", "contacts.Share", "contact_id", c.data.ID, "error", err)
+	}
+
+	return err
+}
+
+// share store grant in dbname, ErrNotFound when dbname doesn't hold the contact
+func (c *contact) share(ctx context.Context, dbname string, share Share) error {
+	return database.WithTx(ctx, dbname, func(ctx context.Context, tx *sqlx.Tx) error {
+		query, err := txStmt(ctx, "share")
+		if err != nil {
+			return err
+		}
+
+		sqlCtx, sqlSpan := tracing.StartSQL(ctx, "share")
+		start := time.Now()
+		res, err := query.ExecContext(sqlCtx, c.tenantID, c.data.ID, share.Grantee, share.Permission)
+		metrics.ObserveStatement("share", start)
+		tracing.End(sqlSpan, err)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+
+		err = database.AppendOutbox(ctx, tx, c.tenantID, topicShared, shareEvent{ContactID: idgen.Format(c.data.ID), Share: share})
+		if err != nil {
+			return err
+		}
+
+		database.AfterCommit(ctx, func() {
+			database.Written(ctx, dbname)
+
+			// list_visible pages depend on grants
+			invalidateLists(ctx, c.tenantID)
+		})
+
+		return nil
+	})
+}
+
+// Unshare revoke grant of the contact from grantee
+func (c *contact) Unshare(ctx context.Context, grantee string) error {
+	level, err := c.access(ctx)
+	if err != nil {
+		return err
+	}
+	if level < accessOwner {
+		return ErrForbidden
+	}
+
+	err = c.onHome(ctx, func(ctx context.Context, dbname string) error {
+		return c.unshare(ctx, dbname, grantee)
+	})
+	if err != nil && err != ErrNotFound {
+		logger.Error(ctx, "fail delete share", "func", "contacts.Unshare", "contact_id", c.data.ID, "error", err)
+	}
+
+	return err
+}
+
+// unshare delete grant from dbname, ErrNotFound when dbname doesn't hold it,
+// grant of contact moved by reshard is deleted from its new database
+func (c *contact) unshare(ctx context.Context, dbname, grantee string) error {
+	return database.WithTx(ctx, dbname, func(ctx context.Context, tx *sqlx.Tx) error {
+		query, err := txStmt(ctx, "unshare")
+		if err != nil {
+			return err
+		}
+
+		sqlCtx, sqlSpan := tracing.StartSQL(ctx, "unshare")
+		start := time.Now()
+		res, err := query.ExecContext(sqlCtx, c.tenantID, c.data.ID, grantee)
+		metrics.ObserveStatement("unshare", start)
+		tracing.End(sqlSpan, err)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+
+		err = database.AppendOutbox(ctx, tx, c.tenantID, topicUnshared, shareEvent{ContactID: idgen.Format(c.data.ID), Share: Share{Grantee: grantee}})
+		if err != nil {
+			return err
+		}
+
+		database.AfterCommit(ctx, func() {
+			database.Written(ctx, dbname)
+
+			// list_visible pages depend on grants
+			invalidateLists(ctx, c.tenantID)
+		})
+
+		return nil
+	})
+}
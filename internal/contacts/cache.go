@@ -0,0 +1,308 @@
+package contacts
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/cache"
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+	"github.com/ffjabbari/go-microservice-sample/internal/tracing"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultContactTTL  = time.Hour
+	defaultListTTL     = time.Minute
+	defaultNotFoundTTL = 30 * time.Second
+
+	// loadTimeout bound shared load, it doesn't end with the request that started it
+	loadTimeout = 5 * time.Second
+)
+
+var cacheConf config.ContactCache
+
+// store holds contacts and list pages, it's replaced by ConfigureCache
+var store = cache.NewRedisStore("main")
+
+// loadGroup coalesce concurrent database load of the same cache key
+var loadGroup singleflight.Group
+
+// sharedLoad load contact once for every concurrent miss of the same key
+// the load isn't cancelled with the request that started it, since other
+// requests wait for its result, but every request stop waiting on its own context
+func sharedLoad(ctx context.Context, tenantID string, contactID int64, cacheKey string) (interface{}, error) {
+	ch := loadGroup.DoChan(cacheKey, func() (interface{}, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout)
+		defer cancel()
+
+		return loadContact(loadCtx, tenantID, contactID, cacheKey)
+	})
+
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// random is replaced in unit test
+var random = rand.Float64
+
+// ConfigureCache is for set store and expiry of cached contacts and list pages,
+// and start in-process cache when it's enabled
+func ConfigureCache(conf config.ContactCache) {
+	cacheConf = conf
+
+	switch conf.Store {
+	case "memory":
+		size := conf.MemorySize
+		if size <= 0 {
+			size = defaultLocalSize
+		}
+		store = cache.NewMemoryStore("contact_memory", size)
+	case "none":
+		store = cache.NewNoopStore()
+	default:
+		store = cache.NewRedisStore("main")
+	}
+
+	if conf.Local.Enabled {
+		startLocalCache()
+	}
+}
+
+func contactTTL() time.Duration {
+	if cacheConf.ContactTTLSeconds > 0 {
+		return time.Duration(cacheConf.ContactTTLSeconds) * time.Second
+	}
+
+	return defaultContactTTL
+}
+
+func listTTL() time.Duration {
+	if cacheConf.ListTTLSeconds > 0 {
+		return time.Duration(cacheConf.ListTTLSeconds) * time.Second
+	}
+
+	return defaultListTTL
+}
+
+func notFoundTTL() time.Duration {
+	if cacheConf.NotFoundTTLSeconds > 0 {
+		return time.Duration(cacheConf.NotFoundTTLSeconds) * time.Second
+	}
+
+	return defaultNotFoundTTL
+}
+
+func earlyRefreshBeta() float64 {
+	if cacheConf.EarlyRefreshBeta > 0 {
+		return cacheConf.EarlyRefreshBeta
+	}
+
+	return 1
+}
+
+// shouldRefresh decide if cached contact is reloaded before it expires
+// the chance grows as expiry gets closer and as loading gets slower,
+// so one request refresh a hot key while the others are still served from cache
+func shouldRefresh(entry cacheEntry, now time.Time) bool {
+	if entry.ExpiresAt == 0 || entry.Delta <= 0 {
+		return false
+	}
+
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	gap := -float64(entry.Delta) * earlyRefreshBeta() * math.Log(random())
+
+	return float64(nowMs)+gap >= float64(entry.ExpiresAt)
+}
+
+// getCachedEntry return cached entry of a contact, false when it's not cached
+// entry written with other schema version is treated as not cached
+func getCachedEntry(ctx context.Context, cacheKey string) (cacheEntry, bool) {
+	_, cacheSpan := tracing.StartRedis(ctx, "GET", cacheKey)
+	entryByte, err := store.Get(cacheKey)
+	if err == cache.ErrMiss {
+		err = nil
+	}
+	tracing.End(cacheSpan, err)
+
+	if err != nil {
+		logger.Warn(ctx, "error get cache", "func", "contacts.getCachedEntry", "key", cacheKey, "error", err)
+		metrics.CacheError("contact")
+		return cacheEntry{}, false
+	}
+
+	entry, ok := decodeEntry(entryByte)
+	if !ok {
+		metrics.CacheMiss("contact")
+		return entry, false
+	}
+
+	metrics.CacheHit("contact")
+	return entry, true
+}
+
+// storeEntry write entry of a contact until ttl
+func storeEntry(ctx context.Context, cacheKey string, entry cacheEntry, ttl time.Duration) error {
+	entryByte, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	_, cacheSpan := tracing.StartRedis(ctx, "SET", cacheKey)
+	err = store.Set(cacheKey, entryByte, ttl)
+	tracing.End(cacheSpan, err)
+	if err != nil && err != cache.ErrCircuitOpen {
+		logger.Warn(ctx, "fail store cache", "func", "contacts.storeEntry", "key", cacheKey, "error", err)
+	}
+
+	return err
+}
+
+// storeNotFound cache id that doesn't exist for a short time
+func storeNotFound(ctx context.Context, cacheKey string) {
+	storeEntry(ctx, cacheKey, cacheEntry{}, notFoundTTL())
+}
+
+// storeCache write contact data to its cache key
+// delta is how long loading the data took, it's used to refresh the entry early
+func (c *contact) storeCache(ctx context.Context, delta time.Duration) error {
+	ttl := contactTTL()
+	data := c.data
+
+	return storeEntry(ctx, c.cacheKey, cacheEntry{
+		Data:      &data,
+		ExpiresAt: time.Now().Add(ttl).UnixNano() / int64(time.Millisecond),
+		Delta:     int64(delta / time.Millisecond),
+	}, ttl)
+}
+
+// dropCache remove contact from redis, in-process cache and cached lists,
+// so the next read goes to database
+func (c *contact) dropCache(ctx context.Context) {
+	_, cacheSpan := tracing.StartRedis(ctx, "DEL", c.cacheKey)
+	tracing.End(cacheSpan, store.Del(c.cacheKey))
+	invalidateLocal(ctx, c.cacheKey)
+	invalidateLists(ctx, c.tenantID)
+}
+
+// tombstone mark deleted contact as not found in redis, in-process cache and cached lists,
+// replica that hasn't replayed the delete still return it, tombstone outlive the lag
+// replica is allowed, so read from it doesn't write the contact back
+func (c *contact) tombstone(ctx context.Context) {
+	ttl := notFoundTTL()
+	for _, dbname := range contactDBs(c.tenantID, c.data.ID) {
+		if lag := database.MaxLag(dbname); lag > ttl {
+			ttl = lag
+		}
+	}
+
+	if err := storeEntry(ctx, c.cacheKey, cacheEntry{}, ttl); err != nil {
+		store.Del(c.cacheKey)
+	}
+	invalidateLocal(ctx, c.cacheKey)
+	invalidateLists(ctx, c.tenantID)
+}
+
+// generationKey holds list generation of a tenant, every cached list page
+// is keyed by it, so replacing it invalidates all pages at once
+func generationKey(tenantID string) string {
+	return fmt.Sprintf("contact:%v:generation", tenantID)
+}
+
+// newGeneration return generation that is never reused
+func newGeneration() []byte {
+	return []byte(fmt.Sprintf("%x%x", time.Now().UnixNano(), rand.Int63()))
+}
+
+// listGeneration return current list generation of a tenant, starting new one if it's missing
+func listGeneration(tenantID string) (string, error) {
+	key := generationKey(tenantID)
+
+	gen, err := store.Get(key)
+	if err == cache.ErrMiss {
+		gen = newGeneration()
+		err = store.Set(key, gen, 0)
+	}
+
+	return string(gen), err
+}
+
+// invalidateLists drop every cached list page of a tenant
+// it's called on any change that can move a contact in or out of a page
+func invalidateLists(ctx context.Context, tenantID string) {
+	key := generationKey(tenantID)
+
+	_, cacheSpan := tracing.StartRedis(ctx, "SET", key)
+	err := store.Set(key, newGeneration(), 0)
+	tracing.End(cacheSpan, err)
+	if err != nil {
+		// missing generation is started again, which invalidates pages too
+		logger.Warn(ctx, "fail invalidate list cache", "func", "contacts.invalidateLists", "key", key, "error", err)
+		store.Del(key)
+	}
+}
+
+// listCacheKey return key of one list page, scope is who the page is visible to
+// the second return value is false when the page can't be cached
+func listCacheKey(ctx context.Context, tenantID, scope string, take, page int64) (string, bool) {
+	gen, err := listGeneration(tenantID)
+	if err != nil {
+		if err != cache.ErrCircuitOpen {
+			logger.Warn(ctx, "fail get list generation", "func", "contacts.listCacheKey", "error", err)
+		}
+		return "", false
+	}
+
+	return fmt.Sprintf("contacts:%v:%v:%v:%v:%v", tenantID, gen, scope, take, page), true
+}
+
+// getCachedList return cached list page, nil when it's not cached
+func getCachedList(ctx context.Context, key string) []ContactData {
+	_, cacheSpan := tracing.StartRedis(ctx, "GET", key)
+	listByte, err := store.Get(key)
+	if err == cache.ErrMiss {
+		tracing.End(cacheSpan, nil)
+		metrics.CacheMiss("contact_list")
+		return nil
+	}
+	tracing.End(cacheSpan, err)
+	if err != nil {
+		logger.Warn(ctx, "error get cache", "func", "contacts.getCachedList", "key", key, "error", err)
+		metrics.CacheError("contact_list")
+		return nil
+	}
+
+	cList, ok := decodeList(listByte)
+	if !ok {
+		metrics.CacheMiss("contact_list")
+		return nil
+	}
+
+	metrics.CacheHit("contact_list")
+	return cList
+}
+
+// storeCachedList store list page until list ttl
+func storeCachedList(ctx context.Context, key string, cList []ContactData) {
+	listByte, err := encodeList(cList)
+	if err != nil {
+		return
+	}
+
+	_, cacheSpan := tracing.StartRedis(ctx, "SET", key)
+	err = store.Set(key, listByte, listTTL())
+	tracing.End(cacheSpan, err)
+	if err != nil {
+		logger.Warn(ctx, "fail store cache", "func", "contacts.storeCachedList", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,117 @@
+package contacts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/idgen"
+)
+
+type (
+	// cacheEntry is what cached for one contact id
+	// Data is nil when the id doesn't exist
+	cacheEntry struct {
+		Version string       `json:"v"`
+		Data    *ContactData `json:"data,omitempty"`
+
+		// ExpiresAt is unix ms when the entry expires
+		ExpiresAt int64 `json:"expires_at,omitempty"`
+
+		// Delta is ms it took to load the data, zero when it's unknown
+		Delta int64 `json:"delta,omitempty"`
+	}
+
+	// cacheList is what cached for one list page
+	cacheList struct {
+		Version string        `json:"v"`
+		Data    []ContactData `json:"data"`
+	}
+)
+
+// schemaVersion is derived from fields of ContactData, so any change
+// of the struct makes entries written by older code unreadable
+var schemaVersion = typeVersion(reflect.TypeOf(ContactData{}))
+
+func typeVersion(t reflect.Type) string {
+	h := sha256.New()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fmt.Fprintf(h, "%s %s %s;", f.Name, f.Type, f.Tag)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+func encodeEntry(entry cacheEntry) ([]byte, error) {
+	entry.Version = schemaVersion
+	return json.Marshal(entry)
+}
+
+// decodeEntry return false when entry is invalid or written with other schema
+func decodeEntry(entryByte []byte) (cacheEntry, bool) {
+	entry := cacheEntry{}
+	if err := json.Unmarshal(entryByte, &entry); err != nil {
+		return entry, false
+	}
+
+	return entry, entry.Version == schemaVersion
+}
+
+func encodeList(cList []ContactData) ([]byte, error) {
+	return json.Marshal(cacheList{Version: schemaVersion, Data: cList})
+}
+
+// decodeList return false when page is invalid or written with other schema
+func decodeList(listByte []byte) ([]ContactData, bool) {
+	list := cacheList{}
+	if err := json.Unmarshal(listByte, &list); err != nil || list.Version != schemaVersion {
+		return nil, false
+	}
+
+	if list.Data == nil {
+		list.Data = []ContactData{}
+	}
+
+	return list.Data, true
+}
+
+// MarshalJSON write id in its string form, 64 bits id doesn't fit number of javascript
+func (cData ContactData) MarshalJSON() ([]byte, error) {
+	type contactAlias ContactData
+	return json.Marshal(struct {
+		ID string `json:"id"`
+		contactAlias
+	}{idgen.Format(cData.ID), contactAlias(cData)})
+}
+
+// UnmarshalJSON accept id in its string form as well as number, like entries cached by older version
+func (cData *ContactData) UnmarshalJSON(data []byte) error {
+	type contactAlias ContactData
+	aux := struct {
+		ID json.RawMessage `json:"id"`
+		*contactAlias
+	}{contactAlias: (*contactAlias)(cData)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(aux.ID) == 0 || string(aux.ID) == "null" {
+		return nil
+	}
+
+	var id string
+	if err := json.Unmarshal(aux.ID, &id); err != nil {
+		return json.Unmarshal(aux.ID, &cData.ID)
+	}
+
+	parsed, err := idgen.Parse(id)
+	if err != nil {
+		return err
+	}
+	cData.ID = parsed
+
+	return nil
+}
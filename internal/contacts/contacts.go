@@ -1,15 +1,22 @@
 package contacts
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"reflect"
 	"regexp"
-	"strconv"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/ffjabbari/go-microservice-sample/internal/cache"
 	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/idgen"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+	"github.com/ffjabbari/go-microservice-sample/internal/shard"
+	"github.com/ffjabbari/go-microservice-sample/internal/tracing"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -18,9 +25,9 @@ type (
 	// PkgContacts object is used to call any method to get single contact object
 	// or any method that doesn't require contact object
 	PkgContacts interface {
-		Get(int64) (Contact, error)
-		List(int64, int64) ([]ContactData, error)
-		Create(ContactData) (Contact, error)
+		Get(context.Context, int64) (Contact, error)
+		List(context.Context, int64, int64) ([]ContactData, error)
+		Create(context.Context, ContactData) (Contact, error)
 	}
 
 	// this struct is the main object of this package
@@ -31,13 +38,22 @@ type (
 	// since this object is created on runtime
 	Contact interface {
 		// For update data
-		Update(ContactData) error
+		Update(context.Context, ContactData) error
 
 		// for delete data
-		Delete() error
+		Delete(context.Context) error
 
 		// for get data
 		Data() ContactData
+
+		// for list grants of this contact
+		Shares(context.Context) ([]Share, error)
+
+		// for grant this contact to other principal
+		Share(context.Context, Share) error
+
+		// for revoke grant of this contact
+		Unshare(context.Context, string) error
 	}
 
 	// this struct is the main object of the package
@@ -45,6 +61,7 @@ type (
 	contact struct {
 		data     ContactData
 		cacheKey string
+		tenantID string
 	}
 
 	// ContactData is the structure of one contact data
@@ -53,54 +70,189 @@ type (
 		Name  string `json:"name" db:"name"`
 		Email string `json:"email" db:"email"`
 		Phone string `json:"phone" db:"phone"`
+
+		// OwnerID is subject of the principal that created the contact
+		OwnerID string `json:"owner_id" db:"owner_id"`
 	}
 )
 
-var stmt map[string]*sqlx.Stmt
+// ErrNotFound is returned when contact is gone before it's written
+var ErrNotFound = errors.New("contact not found")
 
-var phoneRegexp, emailRegexp, nameRegexp *regexp.Regexp
+// ErrInvalidContact is returned when name, email or phone is invalid, or update change nothing
+var ErrInvalidContact = errors.New("invalid contact data")
 
-func prepareQueries() error {
-	// if it's already prepared, don't prepare again
-	if len(stmt) == 0 {
-		stmt = make(map[string]*sqlx.Stmt)
+// ErrConflict is returned when every generated id of new contact is already taken
+var ErrConflict = errors.New("contact id conflict")
 
-		var err error
+// insertAttempts is how many ids are tried before Create give up with ErrConflict
+const insertAttempts = 3
 
-		dbconn, err := database.Conn("main", "slave")
-		if err != nil {
-			log.Panic("[contacts] fail get database connection -> ", err)
-			return err
-		}
+// errIDTaken is returned by insert when generated id is already stored
+var errIDTaken = errors.New("contact id taken")
 
-		// Get 1 contact data from ID
-		stmt["get"], err = dbconn.Preparex(`
-			SELECT
-				id, name, email, phone
-			FROM 
-				contacts
-			WHERE id = $1
-		`)
-		if err != nil {
-			log.Println(err)
-		}
+// outbox topics of contact changes, payload is ContactData,
+// payload of grant changes is shareEvent
+const (
+	topicCreated  = "contact.created"
+	topicUpdated  = "contact.updated"
+	topicDeleted  = "contact.deleted"
+	topicShared   = "contact.shared"
+	topicUnshared = "contact.unshared"
+)
 
-		// Get many list of contact data
-		stmt["list"], err = dbconn.Preparex(`
-			SELECT
-				id, name, email, phone
-			FROM
-				contacts
-			ORDER BY id ASC
-			LIMIT $1
-			OFFSET $2
-		`)
-		if err != nil {
-			log.Println(err)
-		}
+// queries that are prepared on every database that holds contacts,
+// reads on replicas and writes on master
+var queries = map[string]string{
+	// Get 1 contact data from ID
+	"get": `
+		SELECT
+			id, name, email, phone, owner_id
+		FROM
+			contacts
+		WHERE tenant_id = $1
+			AND id = $2
+	`,
+
+	// Get many list of contact data
+	"list": `
+		SELECT
+			id, name, email, phone, owner_id
+		FROM
+			contacts
+		WHERE tenant_id = $1
+		ORDER BY id ASC
+		LIMIT $2
+		OFFSET $3
+	`,
+
+	// Get many list of contact data owned by or shared to a principal
+	"list_visible": `
+		SELECT
+			c.id, c.name, c.email, c.phone, c.owner_id
+		FROM
+			contacts c
+		WHERE c.tenant_id = $1
+			AND (
+				c.owner_id = $2
+				OR EXISTS (
+					SELECT 1
+					FROM contact_shares s
+					WHERE s.tenant_id = c.tenant_id
+						AND s.contact_id = c.id
+						AND s.grantee = $2
+				)
+			)
+		ORDER BY c.id ASC
+		LIMIT $3
+		OFFSET $4
+	`,
+
+	// id is generated by idgen, column default is only used by instances
+	// that still run older version during deploy, taken id return no row
+	// instead of failing, so caller's transaction can go on with another id
+	"insert": `
+		INSERT INTO
+		contacts (
+			id,
+			tenant_id,
+			name,
+			email,
+			phone,
+			owner_id
+		) VALUES (
+			$1,
+			$2,
+			$3,
+			$4,
+			$5,
+			$6
+		)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING id
+	`,
+
+	"update": `
+		UPDATE
+			contacts
+		SET
+			name = $1,
+			email = $2,
+			phone = $3
+		WHERE tenant_id = $4
+			AND id = $5
+	`,
+
+	"delete": `
+		DELETE FROM
+		contacts
+		WHERE tenant_id = $1
+			AND id = $2
+	`,
+
+	// grant is only inserted next to its contact, so contact moved by reshard
+	// never get orphan grant
+	"share": `
+		INSERT INTO
+		contact_shares (
+			tenant_id,
+			contact_id,
+			grantee,
+			permission
+		)
+		SELECT
+			$1,
+			$2,
+			$3,
+			$4
+		WHERE EXISTS (
+			SELECT 1
+			FROM contacts
+			WHERE tenant_id = $1
+				AND id = $2
+			FOR KEY SHARE
+		)
+		ON CONFLICT (tenant_id, contact_id, grantee)
+		DO UPDATE SET permission = EXCLUDED.permission
+	`,
+
+	"unshare": `
+		DELETE FROM
+		contact_shares
+		WHERE tenant_id = $1
+			AND contact_id = $2
+			AND grantee = $3
+	`,
+}
+
+// queryNames keep prepare order stable
+var queryNames = []string{"get", "list", "list_visible", "insert", "update", "delete", "share", "unshare"}
+
+var phoneRegexp, emailRegexp, nameRegexp *regexp.Regexp
+
+// prepareQueries register contact queries and prepare them on every pool of dbname
+func prepareQueries(dbname string) error {
+	for _, name := range queryNames {
+		database.RegisterQuery(stmtName(name), queries[name])
 	}
 
-	return nil
+	return database.WarmStmt(dbname)
+}
+
+// getStmt return prepared statement for read, on replica or on master
+// when the read must see writes of its session
+func getStmt(ctx context.Context, dbname, name string) (*database.Stmt, error) {
+	return database.ReadStmt(ctx, dbname, stmtName(name))
+}
+
+// txStmt return prepared statement bound to transaction of ctx
+func txStmt(ctx context.Context, name string) (*database.Stmt, error) {
+	return database.TxStmt(ctx, stmtName(name))
+}
+
+// stmtName keep contact queries apart from other packages in statement registry
+func stmtName(name string) string {
+	return "contacts." + name
 }
 
 func prepareRegex() {
@@ -108,17 +260,17 @@ func prepareRegex() {
 		var err error
 		phoneRegexp, err = regexp.Compile("^[+]{0,1}[0-9]{8,15}$")
 		if err != nil {
-			log.Println(err)
+			logger.Error(context.Background(), "fail compile regex", "regex", "phone", "error", err)
 		}
 
 		nameRegexp, err = regexp.Compile("^[\\w ]{3,}$")
 		if err != nil {
-			log.Println(err)
+			logger.Error(context.Background(), "fail compile regex", "regex", "name", "error", err)
 		}
 
 		emailRegexp, err = regexp.Compile("^[a-z1-9.-_]{3,}@[a-z1-9-]+([.][a-z1-9-]+){1,}")
 		if err != nil {
-			log.Println(err)
+			logger.Error(context.Background(), "fail compile regex", "regex", "email", "error", err)
 		}
 	}
 }
@@ -126,139 +278,356 @@ func prepareRegex() {
 // New will return contact struct as Contact interface
 // if this run in test, it will return mocked contact struct
 func New() PkgContacts {
-	prepareQueries()
+	// failed statements are prepared again on first use
+	prepareQueries("main")
+	if shard.Enabled() {
+		for _, dbname := range shard.Databases() {
+			prepareQueries(dbname)
+		}
+	}
 	prepareRegex()
 	return &pkgContacts{}
 }
 
 // Get contact by contact id
-func (pkgc *pkgContacts) Get(contactID int64) (Contact, error) {
+func (pkgc *pkgContacts) Get(ctx context.Context, contactID int64) (Contact, error) {
+	ctx, span := tracing.Start(ctx, "contacts.Get")
+	defer span.End()
+
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// check cache first
-	cacheKey := getCacheKey(contactID)
+	cacheKey := getCacheKey(tenantID, contactID)
+
+	// in-process cache is checked before redis, except read that must see
+	// writes of the session, invalidation from other instance may not arrive yet
+	dbs := contactDBs(tenantID, contactID)
+	var cData ContactData
+	ok := false
+	if !consistent(ctx, dbs) && !inTx(ctx, dbs) {
+		cData, ok = localGet(cacheKey)
+	}
+	if !ok {
+		cData, err = fetchContact(ctx, tenantID, contactID, cacheKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if cData.ID == contactID && !inTx(ctx, dbs) {
+			localSet(cacheKey, cData)
+		}
+	}
+
+	// if contact id is different, then id is not found
+	// just return nil
+	if cData.ID != contactID {
+		return nil, nil
+	}
+
+	// init object
+	cObj := contact{data: cData, cacheKey: cacheKey, tenantID: tenantID}
 
-	// get cache data
-	cacheConn, _ := cache.Conn("main")
-	cacheMap, err := cacheConn.HGetAll(cacheKey).Result()
+	// contact that principal can't see is treated as not found
+	level, err := cObj.access(ctx)
 	if err != nil {
-		log.Println("[Get] error get cache from redis ->", err)
+		return nil, err
+	}
+	if level < accessRead {
+		return nil, nil
+	}
+
+	return &cObj, nil
+}
+
+// fetchContact get contact data from cache store, or from database when it's not cached
+// not found id is returned as data with different id
+func fetchContact(ctx context.Context, tenantID string, contactID int64, cacheKey string) (ContactData, error) {
+	// read in transaction see its uncommitted writes, they're never cached
+	if inTx(ctx, contactDBs(tenantID, contactID)) {
+		return queryContact(ctx, tenantID, contactID)
+	}
+
+	// cache that is down or bypassed is treated as a miss
+	entry, cached := getCachedEntry(ctx, cacheKey)
+
+	// cached not found id
+	if cached && entry.Data == nil {
+		return ContactData{}, nil
 	}
 
-	// init empty object
-	cObj := contact{cacheKey: cacheKey}
 	cData := ContactData{}
+	if cached {
+		cData = *entry.Data
+	}
+
+	// entry close to expiry is refreshed early by a few requests,
+	// so a hot key doesn't expire under load
+	refresh := cached && shouldRefresh(entry, time.Now())
 
 	// if cache is empty, then we need to do query
-	if len(cacheMap) == 0 {
-		// get data from DB
-		err := stmt["get"].QueryRowx(contactID).StructScan(&cData)
-		if err != nil {
-			log.Println("[Get] error get data from query ->", err)
-			return nil, err
+	// concurrent misses of the same key share one query, except read that
+	// must see its own writes, it can't take result of other request
+	if !cached || refresh {
+		var loaded interface{}
+		var err error
+		if consistent(ctx, contactDBs(tenantID, contactID)) {
+			loaded, err = loadContact(ctx, tenantID, contactID, cacheKey)
+		} else {
+			loaded, err = sharedLoad(ctx, tenantID, contactID, cacheKey)
 		}
 
-		// if contact id is different, then id is not found
-		// just return nil
-		if cData.ID != contactID {
-			return nil, nil
+		switch {
+		case err == nil:
+			cData = loaded.(ContactData)
+		case refresh:
+			logger.Warn(ctx, "fail refresh cache, use cached data", "func", "contacts.fetchContact", "contact_id", contactID, "error", err)
+		default:
+			return cData, err
 		}
+	}
 
-		// prepare cache data
-		cacheData := make(map[string]string)
-		cacheData["id"] = strconv.FormatInt(cData.ID, 10)
-		cacheData["name"] = cData.Name
-		cacheData["email"] = cData.Email
-		cacheData["phone"] = cData.Phone
+	return cData, nil
+}
 
-		// store cache data
-		err = cacheConn.HMSet(cacheKey, cacheData).Err()
-		if err != nil {
-			log.Println("[Get] fail store cache ->", err)
-		}
-	} else {
-		if val, ok := cacheMap["id"]; ok {
-			cData.ID, _ = strconv.ParseInt(val, 10, 64)
-		}
+// loadContact get contact data from database and store it to cache
+// not found id is returned as empty data and cached for a short time
+func loadContact(ctx context.Context, tenantID string, contactID int64, cacheKey string) (ContactData, error) {
+	start := time.Now()
+	cData, err := queryContact(ctx, tenantID, contactID)
+	if err != nil {
+		return cData, err
+	}
 
-		if val, ok := cacheMap["name"]; ok {
-			cData.Name = val
-		}
+	if cData.ID != contactID {
+		storeNotFound(ctx, cacheKey)
+		return ContactData{}, nil
+	}
 
-		if val, ok := cacheMap["email"]; ok {
-			cData.Email = val
+	cObj := contact{data: cData, cacheKey: cacheKey, tenantID: tenantID}
+	cObj.storeCache(ctx, time.Since(start))
+
+	return cData, nil
+}
+
+// queryContact get contact data from database, not found id is returned as empty data
+func queryContact(ctx context.Context, tenantID string, contactID int64) (ContactData, error) {
+	cData := ContactData{}
+
+	// contact that's being resharded is looked up where it's moved from first,
+	// reshard copy it before deleting it there, so it's never missed in between
+	dbs := contactDBs(tenantID, contactID)
+	for i := len(dbs) - 1; i >= 0; i-- {
+		query, err := getStmt(ctx, dbs[i], "get")
+		if err != nil {
+			return cData, err
 		}
 
-		if val, ok := cacheMap["phone"]; ok {
-			cData.Phone = val
+		// get data from DB
+		sqlCtx, sqlSpan := tracing.StartSQL(ctx, "get")
+		start := time.Now()
+		err = query.QueryRowxContext(sqlCtx, tenantID, contactID).StructScan(&cData)
+		metrics.ObserveStatement("get", start)
+		tracing.End(sqlSpan, err)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			logger.Error(ctx, "error get data from query", "func", "contacts.queryContact", "contact_id", contactID, "database", dbs[i], "error", err)
+			return cData, err
 		}
+		break
 	}
 
-	// fill data
-	cObj.data = cData
+	if cData.ID != contactID {
+		return ContactData{}, nil
+	}
 
-	return &cObj, nil
+	return cData, nil
 }
 
 // Create new contact
-func (pkgc *pkgContacts) Create(input ContactData) (Contact, error) {
+func (pkgc *pkgContacts) Create(ctx context.Context, input ContactData) (Contact, error) {
+	ctx, span := tracing.Start(ctx, "contacts.Create")
+	defer span.End()
+
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := principalFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !canEdit(p) {
+		return nil, ErrForbidden
+	}
+
 	// return if invalid
 	if !validateContact(input) {
-		return nil, errors.New("invalid contact data")
+		return nil, ErrInvalidContact
 	}
 
-	dbconn, _ := database.Conn("main", "master")
+	// creator always own the contact
+	input.OwnerID = p.Subject
+
+	// id that is already taken, by instance sharing node or older version,
+	// is replaced by a fresh one
+	for attempt := 0; attempt < insertAttempts; attempt++ {
+		cObj, err := pkgc.insert(ctx, tenantID, input)
+		if err != errIDTaken {
+			if err != nil {
+				logger.Error(ctx, "fail insert contact", "func", "contacts.Create", "error", err)
+				return nil, err
+			}
+			return cObj, nil
+		}
 
-	tx, _ := dbconn.Beginx()
-	defer tx.Rollback()
+		logger.Warn(ctx, "generated contact id is taken", "func", "contacts.Create", "attempt", attempt+1)
+	}
 
-	var insertID int64
+	logger.Error(ctx, "fail insert contact", "func", "contacts.Create", "error", ErrConflict)
+	return nil, ErrConflict
+}
 
-	err := tx.QueryRowx(`
-			INSERT INTO
-			contacts
-				name,
-				email,
-				phone
-			VALUES (
-				$1,
-				$2,
-				$3
-			) returning id
-		`, input.Name, input.Email, input.Phone).Scan(&insertID)
+// insert store contact under a new id, errIDTaken is returned when the id is already stored
+func (pkgc *pkgContacts) insert(ctx context.Context, tenantID string, input ContactData) (*contact, error) {
+	// id is generated before insert, since the id choose the shard
+	newID := idgen.Next()
+	dbname := contactDBs(tenantID, newID)[0]
 
-	if err != nil {
-		return nil, err
-	}
+	// transient failure run the whole transaction again,
+	// inside caller's transaction it's committed together with the caller
+	var cObj contact
+	err := database.WithTx(ctx, dbname, func(ctx context.Context, tx *sqlx.Tx) error {
+		var insertID int64
+
+		query, err := txStmt(ctx, "insert")
+		if err != nil {
+			return err
+		}
+
+		sqlCtx, sqlSpan := tracing.StartSQL(ctx, "insert")
+		start := time.Now()
+		err = query.QueryRowxContext(sqlCtx, newID, tenantID, input.Name, input.Email, input.Phone, input.OwnerID).Scan(&insertID)
+		metrics.ObserveStatement("insert", start)
+		if err == sql.ErrNoRows {
+			err = errIDTaken
+		}
+		tracing.End(sqlSpan, err)
+		if err != nil {
+			return err
+		}
+
+		input.ID = insertID
+		cObj = contact{data: input, cacheKey: getCacheKey(tenantID, insertID), tenantID: tenantID}
 
-	err = tx.Commit()
+		err = database.AppendOutbox(ctx, tx, tenantID, topicCreated, input)
+		if err != nil {
+			return err
+		}
+
+		database.AfterCommit(ctx, func() {
+			// next reads of the session must see the new contact
+			database.Written(ctx, dbname)
+
+			// new contact is usually read right after it's created
+			cObj.storeCache(ctx, 0)
+			invalidateLists(ctx, tenantID)
+		})
+
+		return nil
+	})
 	if err != nil {
-		log.Println("[Create] fail to commit ->", err)
 		return nil, err
 	}
 
-	input.ID = insertID
-	cObj := contact{data: input, cacheKey: getCacheKey(insertID)}
-
 	return &cObj, nil
 }
 
 // List wil return list of contact data
-func (pkgc *pkgContacts) List(take, page int64) ([]ContactData, error) {
+func (pkgc *pkgContacts) List(ctx context.Context, take, page int64) ([]ContactData, error) {
+	ctx, span := tracing.Start(ctx, "contacts.List")
+	defer span.End()
+
+	tenantID, err := TenantFromContext(ctx)
+	if err != nil {
+		return []ContactData{}, err
+	}
 
 	// validate input
 	if take <= 0 || page <= 0 {
 		return []ContactData{}, errors.New("Invalid input")
 	}
 
+	p, err := principalFromContext(ctx)
+	if err != nil {
+		return []ContactData{}, err
+	}
+
 	// calculate offset
 	offset := take * (page - 1)
 
-	rows, err := stmt["list"].Queryx(take, offset)
+	// admin see every contact, others only see contacts owned by or shared to them
+	stmtName := "list"
+	scope := "all"
+	args := []interface{}{tenantID, take, offset}
+	if !p.HasRole(RoleAdmin) {
+		stmtName = "list_visible"
+		scope = "visible:" + p.Subject
+		args = []interface{}{tenantID, p.Subject, take, offset}
+	}
+
+	// check cache first, page cached from lagging replica may miss writes of the session
+	// page read in transaction may hold its uncommitted writes, it isn't cached
+	dbs := listDBs(tenantID)
+	cacheKey, cacheable := listCacheKey(ctx, tenantID, scope, take, page)
+	cacheable = cacheable && !inTx(ctx, dbs)
+	if cacheable && !consistent(ctx, dbs) {
+		if cList := getCachedList(ctx, cacheKey); cList != nil {
+			return cList, nil
+		}
+	}
+
+	var cList []ContactData
+	if len(dbs) == 1 {
+		cList, err = listFrom(ctx, dbs[0], stmtName, args)
+	} else {
+		cList, err = listShards(ctx, dbs, stmtName, args, take, offset)
+	}
 	if err != nil {
-		log.Println("[List] error on query ->", err)
+		logger.Error(ctx, "error on query", "func", "contacts.List", "error", err)
 		return []ContactData{}, err
 	}
 
+	if cacheable {
+		storeCachedList(ctx, cacheKey, cList)
+	}
+
+	return cList, nil
+}
+
+// listFrom query one page of contacts from dbname
+func listFrom(ctx context.Context, dbname, stmtName string, args []interface{}) ([]ContactData, error) {
+	query, err := getStmt(ctx, dbname, stmtName)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlCtx, sqlSpan := tracing.StartSQL(ctx, stmtName)
+	start := time.Now()
+	rows, err := query.QueryxContext(sqlCtx, args...)
+	metrics.ObserveStatement(stmtName, start)
+	tracing.End(sqlSpan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
 	cList := []ContactData{}
 	for rows.Next() {
 		cData := ContactData{}
@@ -266,11 +635,76 @@ func (pkgc *pkgContacts) List(take, page int64) ([]ContactData, error) {
 		cList = append(cList, cData)
 	}
 
-	return cList, nil
+	return cList, rows.Err()
+}
+
+// listShards query every shard concurrently and merge them into one page
+// page of merged list may start anywhere in each shard, so every shard
+// return everything up to the end of the page, deep page cost more
+func listShards(ctx context.Context, dbs []string, stmtName string, args []interface{}, take, offset int64) ([]ContactData, error) {
+	// limit and offset are the last two args of both list queries
+	shardArgs := append([]interface{}{}, args...)
+	shardArgs[len(args)-2] = offset + take
+	shardArgs[len(args)-1] = int64(0)
+
+	lists := make([][]ContactData, len(dbs))
+	errs := make([]error, len(dbs))
+
+	var wg sync.WaitGroup
+	for i, dbname := range dbs {
+		wg.Add(1)
+		go func(i int, dbname string) {
+			defer wg.Done()
+			lists[i], errs[i] = listFrom(ctx, dbname, stmtName, shardArgs)
+		}(i, dbname)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("list contacts from %s: %w", dbs[i], err)
+		}
+	}
+
+	return mergeLists(lists, take, offset), nil
+}
+
+// mergeLists merge lists sorted by id and return the page of take from offset
+// contact copied by reshard but not yet deleted from its old shard is listed once
+func mergeLists(lists [][]ContactData, take, offset int64) []ContactData {
+	merged := []ContactData{}
+	for _, l := range lists {
+		merged = append(merged, l...)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].ID < merged[j].ID
+	})
+
+	unique := merged[:0]
+	for _, cData := range merged {
+		if len(unique) > 0 && unique[len(unique)-1].ID == cData.ID {
+			continue
+		}
+		unique = append(unique, cData)
+	}
+
+	if offset >= int64(len(unique)) {
+		return []ContactData{}
+	}
+
+	end := offset + take
+	if end > int64(len(unique)) {
+		end = int64(len(unique))
+	}
+
+	return unique[offset:end]
 }
 
 // Update contact data
-func (c *contact) Update(input ContactData) error {
+func (c *contact) Update(ctx context.Context, input ContactData) error {
+	ctx, span := tracing.Start(ctx, "contacts.Update")
+	defer span.End()
 
 	data := c.data
 
@@ -288,71 +722,151 @@ func (c *contact) Update(input ContactData) error {
 
 	// check if there's any changes
 	if reflect.DeepEqual(data, c.data) {
-		return errors.New("no data is updated")
+		return ErrInvalidContact
 	}
 
 	// validate new data
 	if !validateContact(data) {
-		return errors.New("contact data is invalid")
+		return ErrInvalidContact
 	}
 
-	// get db conn
-	dbconn, _ := database.Conn("main", "master")
-	tx, _ := dbconn.Beginx()
-	defer tx.Rollback()
+	level, err := c.access(ctx)
+	if err != nil {
+		return err
+	}
+	if level < accessWrite {
+		return ErrForbidden
+	}
 
-	_, err := tx.Exec(`
-		UPDATE
-			contacts
-		SET
-			name = $1,
-			email = $2,
-			phone = $3
-		WHERE id = $4
-	`, data.Name, data.Email, data.Phone, data.ID)
+	err = c.onHome(ctx, func(ctx context.Context, dbname string) error {
+		return c.update(ctx, dbname, data)
+	})
+	if err == ErrNotFound {
+		// cached data is stale, drop it instead of writing it through
+		c.dropCache(ctx)
+		return err
+	}
 	if err != nil {
+		logger.Error(ctx, "fail update contact", "func", "contacts.Update", "contact_id", c.data.ID, "error", err)
 		return err
 	}
 
-	tx.Commit()
+	return nil
+}
 
-	// delete cache data
-	cacheConn, _ := cache.Conn("main")
-	cacheConn.Del(c.cacheKey)
+// update write data of the contact to dbname, ErrNotFound when dbname doesn't hold it
+func (c *contact) update(ctx context.Context, dbname string, data ContactData) error {
+	return database.WithTx(ctx, dbname, func(ctx context.Context, tx *sqlx.Tx) error {
+		query, err := txStmt(ctx, "update")
+		if err != nil {
+			return err
+		}
 
-	// update struct data
-	c.data = data
+		sqlCtx, sqlSpan := tracing.StartSQL(ctx, "update")
+		start := time.Now()
+		res, err := query.ExecContext(sqlCtx, data.Name, data.Email, data.Phone, c.tenantID, data.ID)
+		metrics.ObserveStatement("update", start)
+		tracing.End(sqlSpan, err)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		// contact was deleted, or moved by reshard, after it's read
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+
+		err = database.AppendOutbox(ctx, tx, c.tenantID, topicUpdated, data)
+		if err != nil {
+			return err
+		}
+
+		database.AfterCommit(ctx, func() {
+			database.Written(ctx, dbname)
+
+			// update struct data, and write it through to cache
+			c.data = data
+			if err := c.storeCache(ctx, 0); err != nil {
+				store.Del(c.cacheKey)
+			}
+			invalidateLocal(ctx, c.cacheKey)
+			invalidateLists(ctx, c.tenantID)
+		})
+
+		return nil
+	})
 }
 
-func (c *contact) Delete() error {
+func (c *contact) Delete(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "contacts.Delete")
+	defer span.End()
 
-	// get db conn
-	dbconn, _ := database.Conn("main", "master")
-	tx, _ := dbconn.Beginx()
-	defer tx.Rollback()
+	// only owner and admin can delete, write grant isn't enough
+	level, err := c.access(ctx)
+	if err != nil {
+		return err
+	}
+	if level < accessOwner {
+		return ErrForbidden
+	}
 
-	_, err := tx.Exec(`
-		DELETE FROM
-		contacts
-		WHERE id = $1
-		LIMIT 1
-	`, c.data.ID)
+	err = c.onHome(ctx, c.delete)
+	if err == ErrNotFound {
+		// contact is already gone, cached data is stale
+		c.tombstone(ctx)
+		return err
+	}
 	if err != nil {
+		logger.Error(ctx, "fail delete contact", "func", "contacts.Delete", "contact_id", c.data.ID, "error", err)
 		return err
 	}
 
-	tx.Commit()
+	return nil
+}
 
-	// delete cache data
-	cacheConn, _ := cache.Conn("main")
-	cacheConn.Del(c.cacheKey)
+// delete remove the contact from dbname, ErrNotFound when dbname doesn't hold it
+func (c *contact) delete(ctx context.Context, dbname string) error {
+	return database.WithTx(ctx, dbname, func(ctx context.Context, tx *sqlx.Tx) error {
+		query, err := txStmt(ctx, "delete")
+		if err != nil {
+			return err
+		}
 
-	// destroy obj
-	c = nil
+		sqlCtx, sqlSpan := tracing.StartSQL(ctx, "delete")
+		start := time.Now()
+		res, err := query.ExecContext(sqlCtx, c.tenantID, c.data.ID)
+		metrics.ObserveStatement("delete", start)
+		tracing.End(sqlSpan, err)
+		if err != nil {
+			return err
+		}
 
-	return nil
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+
+		err = database.AppendOutbox(ctx, tx, c.tenantID, topicDeleted, c.data)
+		if err != nil {
+			return err
+		}
+
+		database.AfterCommit(ctx, func() {
+			database.Written(ctx, dbname)
+
+			// cache it as not found, lagging replica would bring it back otherwise
+			c.tombstone(ctx)
+		})
+
+		return nil
+	})
 }
 
 func (c *contact) Data() ContactData {
@@ -375,6 +889,7 @@ func validateContact(input ContactData) bool {
 	return true
 }
 
-func getCacheKey(contactID int64) string {
-	return fmt.Sprintf("contact:%v", contactID)
+// getCacheKey include tenant id, so tenants never share cache entry
+func getCacheKey(tenantID string, contactID int64) string {
+	return fmt.Sprintf("contact:%v:%v", tenantID, contactID)
 }
@@ -1,35 +1,51 @@
 package contacts
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"log"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ffjabbari/go-microservice-sample/internal/auth"
 	"github.com/ffjabbari/go-microservice-sample/internal/cache"
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
 	"github.com/ffjabbari/go-microservice-sample/internal/database"
 
 	"github.com/alicebob/miniredis"
 	"github.com/jmoiron/sqlx"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"gopkg.in/redis.v5"
 )
 
 // sqlmock obj
 var mock sqlmock.Sqlmock
+var mockDB *sqlx.DB
 var pkgCon PkgContacts
 var prepared map[string]*sqlmock.ExpectedPrepare
 
+// every test run as admin of this tenant
+var tenantCtx = auth.WithPrincipal(
+	WithTenant(context.Background(), "tenant1"),
+	&auth.Principal{Subject: "user1", TenantID: "tenant1", Roles: []string{RoleAdmin}},
+)
+
 // this init function will only called when run unit test
 func init() {
 	// create new sqlmock obj
 	db, mockinit, err := sqlmock.New()
 	if err != nil {
-		log.Println("an error '%s' was not expected when opening a stub database connection", err)
+		log.Printf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	sqlxMock := sqlx.NewDb(db, "postgres")
 
 	// apply sqlmock obj as global variable
 	mock = mockinit
+	mockDB = sqlxMock
 
 	// Create mock db connection
 	err = database.MockDB(sqlxMock, []string{"main"})
@@ -44,8 +60,8 @@ func init() {
 	}
 
 	// Create mock redis connection
-	cacheConf := make(map[string]string)
-	cacheConf["main"] = s.Addr()
+	cacheConf := make(map[string]config.Redis)
+	cacheConf["main"] = config.Redis{Addr: s.Addr()}
 	cache.ConnectRedis(cacheConf)
 }
 
@@ -53,8 +69,14 @@ func TestNew(t *testing.T) {
 
 	// expect all prepared queries
 	prepared = make(map[string]*sqlmock.ExpectedPrepare)
-	prepared["get"] = mock.ExpectPrepare("(?i)SELECT id, name, email, phone FROM contacts WHERE id = (.+)")
-	prepared["list"] = mock.ExpectPrepare("(?i)SELECT id, name, email, phone FROM contacts ORDER BY id ASC LIMIT (.+) OFFSET (.+)")
+	prepared["get"] = mock.ExpectPrepare("(?i)SELECT id, name, email, phone, owner_id FROM contacts WHERE tenant_id = (.+) AND id = (.+)")
+	prepared["list"] = mock.ExpectPrepare("(?i)SELECT id, name, email, phone, owner_id FROM contacts WHERE tenant_id = (.+) ORDER BY id ASC LIMIT (.+) OFFSET (.+)")
+	prepared["list_visible"] = mock.ExpectPrepare("(?i)SELECT (.+) FROM contacts c WHERE c.tenant_id = (.+) ORDER BY c.id ASC LIMIT (.+) OFFSET (.+)")
+	prepared["insert"] = mock.ExpectPrepare("(?i)INSERT INTO contacts (.+) VALUES (.+)")
+	prepared["update"] = mock.ExpectPrepare("(?i)UPDATE contacts SET (.+) WHERE tenant_id = (.+) AND id = (.+)")
+	prepared["delete"] = mock.ExpectPrepare("(?i)DELETE FROM contacts WHERE tenant_id = (.+) AND id = (.+)")
+	prepared["share"] = mock.ExpectPrepare("(?i)INSERT INTO contact_shares (.+) WHERE EXISTS (.+) ON CONFLICT")
+	prepared["unshare"] = mock.ExpectPrepare("(?i)DELETE FROM contact_shares WHERE tenant_id = (.+) AND contact_id = (.+) AND grantee = (.+)")
 
 	// create pkgcon obj
 	pkgCon = New()
@@ -73,6 +95,7 @@ func TestGet(t *testing.T) {
 	})
 
 	testCase := []struct {
+		Ctx            context.Context
 		ID             int64
 		Rows           sqlmock.Rows
 		QueryError     bool
@@ -80,36 +103,64 @@ func TestGet(t *testing.T) {
 		ExpectedResult Contact
 	}{
 		{
+			tenantCtx,
 			1,
 			table.AddRow(1, "user1", "user1@email.com", "+628123456789"),
 			false,
 			false,
-			&contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:1"},
+			&contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:tenant1:1", tenantID: "tenant1"},
 		},
 		{
+			tenantCtx,
 			1,
 			nil,
 			false,
 			false,
-			&contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:1"},
+			&contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:tenant1:1", tenantID: "tenant1"},
 		},
 		{
+			tenantCtx,
 			2,
 			nil,
 			true,
 			true,
 			nil,
 		},
+		{
+			context.Background(),
+			1,
+			nil,
+			false,
+			true,
+			nil,
+		},
+		// not found id is cached, so the second get doesn't query
+		{
+			tenantCtx,
+			3,
+			sqlmock.NewRows([]string{"id"}),
+			false,
+			false,
+			nil,
+		},
+		{
+			tenantCtx,
+			3,
+			nil,
+			false,
+			false,
+			nil,
+		},
 	}
 
 	for index, tcase := range testCase {
 		if tcase.QueryError {
-			prepared["get"].ExpectQuery().WithArgs(tcase.ID).WillReturnError(errors.New("sql error"))
+			prepared["get"].ExpectQuery().WithArgs("tenant1", tcase.ID).WillReturnError(errors.New("sql error"))
 		} else if tcase.Rows != nil {
-			prepared["get"].ExpectQuery().WithArgs(tcase.ID).WillReturnRows(tcase.Rows)
+			prepared["get"].ExpectQuery().WithArgs("tenant1", tcase.ID).WillReturnRows(tcase.Rows)
 		}
 
-		res, err := pkgCon.Get(tcase.ID)
+		res, err := pkgCon.Get(tcase.Ctx, tcase.ID)
 		if (err != nil && !tcase.ExpectError) || (err == nil && tcase.ExpectError) {
 			t.Errorf("[TestGet] tcase:%v err got %v | expected err!=nil->%v", index, err, tcase.ExpectError)
 		}
@@ -125,6 +176,244 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestSharedLoad(t *testing.T) {
+	prepared["get"].ExpectQuery().WithArgs("tenant1", 4).WillDelayFor(50 * time.Millisecond).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "phone"}).AddRow(4, "user4", "user4@email.com", "+628123456789"))
+
+	// request that started the load is gone while it's running
+	leaderCtx, cancel := context.WithCancel(tenantCtx)
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := sharedLoad(leaderCtx, "tenant1", 4, "contact:tenant1:4")
+		leaderErr <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	loaded, err := sharedLoad(tenantCtx, "tenant1", 4, "contact:tenant1:4")
+	if err != nil || loaded.(ContactData).ID != 4 {
+		t.Errorf("[TestSharedLoad] waiter got %v %v | expected contact 4", loaded, err)
+	}
+
+	if err := <-leaderErr; err != context.Canceled {
+		t.Errorf("[TestSharedLoad] leader err got %v | expected %v", err, context.Canceled)
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+// fakeSubscription return queued replies, then timeout, ping fail when pingErr is set
+type fakeSubscription struct {
+	replies []interface{}
+	pingErr error
+	pinged  int
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (f *fakeSubscription) ReceiveTimeout(time.Duration) (interface{}, error) {
+	if len(f.replies) == 0 {
+		return nil, timeoutError{}
+	}
+
+	reply := f.replies[0]
+	f.replies = f.replies[1:]
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (f *fakeSubscription) Ping(...string) error {
+	f.pinged++
+	return f.pingErr
+}
+
+func TestReceiveInvalidation(t *testing.T) {
+	lru := cache.NewLRU("contact_local_test", 10, time.Minute)
+
+	testCase := []struct {
+		Replies      []interface{}
+		PingErr      error
+		ExpectedKeys []bool
+		ExpectedPing int
+	}{
+		// published key is removed, other key stays
+		{[]interface{}{&redis.Message{Channel: invalidationChannel, Payload: "contact:tenant1:1"}, errors.New("closed")}, nil, []bool{false, true}, 0},
+		// new subscription may follow lost messages, so everything is purged
+		{[]interface{}{&redis.Subscription{Kind: "subscribe", Channel: invalidationChannel}, errors.New("closed")}, nil, []bool{false, false}, 0},
+		// idle connection is pinged, and dead one is returned
+		{[]interface{}{}, errors.New("broken pipe"), []bool{true, true}, 1},
+		{[]interface{}{&redis.Pong{}}, errors.New("broken pipe"), []bool{true, true}, 1},
+	}
+
+	for index, tcase := range testCase {
+		lru.Set("contact:tenant1:1", ContactData{ID: 1})
+		lru.Set("contact:tenant1:2", ContactData{ID: 2})
+
+		sub := &fakeSubscription{replies: tcase.Replies, pingErr: tcase.PingErr}
+		if err := receiveInvalidation(sub, lru); err == nil {
+			t.Errorf("[TestReceiveInvalidation] tcase:%v err got nil | expected error", index)
+		}
+
+		for i, expected := range tcase.ExpectedKeys {
+			if _, ok := lru.Get(getCacheKey("tenant1", int64(i+1))); ok != expected {
+				t.Errorf("[TestReceiveInvalidation] tcase:%v key %v cached got %v | expected %v", index, i+1, ok, expected)
+			}
+		}
+
+		if sub.pinged != tcase.ExpectedPing {
+			t.Errorf("[TestReceiveInvalidation] tcase:%v ping got %v | expected %v", index, sub.pinged, tcase.ExpectedPing)
+		}
+	}
+}
+
+func TestGetInTx(t *testing.T) {
+	mock.ExpectBegin()
+	prepared["get"].ExpectQuery().WithArgs("tenant1", 5).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "phone"}).AddRow(5, "uncommitted", "user5@email.com", "+628123456789"))
+	mock.ExpectRollback()
+
+	// read in transaction see its writes, and doesn't cache them
+	database.WithTx(tenantCtx, "main", func(ctx context.Context, tx *sqlx.Tx) error {
+		cObj, err := pkgCon.Get(ctx, 5)
+		if err != nil || cObj == nil || cObj.Data().Name != "uncommitted" {
+			t.Errorf("[TestGetInTx] get got %v %v | expected uncommitted contact", cObj, err)
+		}
+		return errors.New("rollback")
+	})
+
+	if entry, cached := getCachedEntry(tenantCtx, "contact:tenant1:5"); cached {
+		t.Errorf("[TestGetInTx] cache got %v | expected miss", entry.Data)
+	}
+
+	if _, cached := localGet("contact:tenant1:5"); cached {
+		t.Errorf("[TestGetInTx] local cache got cached | expected miss")
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestShareInTx(t *testing.T) {
+	mock.ExpectBegin()
+	prepared["share"].ExpectExec().WithArgs("tenant1", 5, "user2", PermissionWrite).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("(?i)INSERT INTO outbox").WithArgs("tenant1", topicShared, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	// grant is written in the caller's transaction, and rolled back with it
+	cObj := &contact{data: ContactData{ID: 5, OwnerID: "user1"}, cacheKey: "contact:tenant1:5", tenantID: "tenant1"}
+	database.WithTx(tenantCtx, "main", func(ctx context.Context, tx *sqlx.Tx) error {
+		if err := cObj.Share(ctx, Share{Grantee: "user2", Permission: PermissionWrite}); err != nil {
+			t.Errorf("[TestShareInTx] share err got %v | expected nil", err)
+		}
+		return errors.New("rollback")
+	})
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestShouldRefresh(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	// -ln(0.5) is about 0.69, so entry is refreshed within 0.69*delta before expiry
+	random = func() float64 {
+		return 0.5
+	}
+	defer func() {
+		random = rand.Float64
+	}()
+
+	testCase := []struct {
+		Entry          cacheEntry
+		ExpectedResult bool
+	}{
+		{cacheEntry{ExpiresAt: nowMs + 1000, Delta: 100}, false},
+		{cacheEntry{ExpiresAt: nowMs + 50, Delta: 100}, true},
+		{cacheEntry{ExpiresAt: nowMs - 1, Delta: 100}, true},
+		// written by create or update, loading time is unknown
+		{cacheEntry{ExpiresAt: nowMs + 50}, false},
+		{cacheEntry{}, false},
+	}
+
+	for index, tcase := range testCase {
+		res := shouldRefresh(tcase.Entry, now)
+		if res != tcase.ExpectedResult {
+			t.Errorf("[TestShouldRefresh] tcase:%v res got %v | expected %v", index, res, tcase.ExpectedResult)
+		}
+	}
+}
+
+func TestDecodeEntry(t *testing.T) {
+	data := ContactData{ID: 1, Name: "user1"}
+	current, _ := encodeEntry(cacheEntry{Data: &data})
+
+	testCase := []struct {
+		EntryByte      []byte
+		ExpectedResult bool
+	}{
+		{current, true},
+		// written before ContactData is changed
+		{[]byte(`{"v":"000000000000","data":{"id":1,"name":"user1"}}`), false},
+		{[]byte(`not json`), false},
+	}
+
+	for index, tcase := range testCase {
+		entry, ok := decodeEntry(tcase.EntryByte)
+		if ok != tcase.ExpectedResult {
+			t.Errorf("[TestDecodeEntry] tcase:%v ok got %v | expected %v", index, ok, tcase.ExpectedResult)
+		}
+
+		if ok && !reflect.DeepEqual(*entry.Data, data) {
+			t.Errorf("[TestDecodeEntry] tcase:%v data got %v | expected %v", index, *entry.Data, data)
+		}
+	}
+}
+
+func TestContactDataJSON(t *testing.T) {
+	testCase := []struct {
+		Input    string
+		Expected int64
+		Valid    bool
+	}{
+		{`{"id":"0000000000007","name":"User1"}`, 7, true},
+		// numeric id written before ids were formatted
+		{`{"id":7,"name":"User1"}`, 7, true},
+		{`{"name":"User1"}`, 0, true},
+		{`{"id":"not an id","name":"User1"}`, 0, false},
+	}
+
+	for index, tcase := range testCase {
+		cData := ContactData{}
+		err := json.Unmarshal([]byte(tcase.Input), &cData)
+		if (err == nil) != tcase.Valid {
+			t.Errorf("[TestContactDataJSON] tcase:%v error %v | expected valid %v", index, err, tcase.Valid)
+			continue
+		}
+
+		if tcase.Valid && (cData.ID != tcase.Expected || cData.Name != "User1") {
+			t.Errorf("[TestContactDataJSON] tcase:%v got %v | expected id %v", index, cData, tcase.Expected)
+		}
+	}
+
+	encoded, _ := json.Marshal(ContactData{ID: 7, Name: "User1"})
+	if !strings.Contains(string(encoded), `"id":"0000000000007"`) {
+		t.Errorf("[TestContactDataJSON] encoded got %s | expected string id", encoded)
+	}
+}
+
 func TestCreate(t *testing.T) {
 	table := sqlmock.NewRows([]string{
 		"id",
@@ -142,7 +431,7 @@ func TestCreate(t *testing.T) {
 			table.AddRow(1),
 			false,
 			false,
-			&contact{data: ContactData{ID: 1, Name: "User1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:1"},
+			&contact{data: ContactData{ID: 1, Name: "User1", Email: "user1@email.com", Phone: "+628123456789", OwnerID: "user1"}, cacheKey: "contact:tenant1:1", tenantID: "tenant1"},
 		},
 		{
 			ContactData{Name: "User2", Email: "user2@email.com", Phone: "+628123456780"},
@@ -153,18 +442,34 @@ func TestCreate(t *testing.T) {
 		},
 	}
 
+	// taken id is replaced by a fresh one, until attempts run out
+	conflictCase := []struct {
+		Taken          int
+		ExpectedError  error
+		ExpectedResult Contact
+	}{
+		{1, nil, &contact{data: ContactData{ID: 2, Name: "User3", Email: "user3@email.com", Phone: "+628123456781", OwnerID: "user1"}, cacheKey: "contact:tenant1:2", tenantID: "tenant1"}},
+		{insertAttempts, ErrConflict, nil},
+	}
+
+	input := ContactData{Name: "User3", Email: "user3@email.com", Phone: "+628123456781"}
+	if _, err := pkgCon.Create(tenantCtx, ContactData{Name: "User3 !@#"}); err != ErrInvalidContact {
+		t.Errorf("[TestCreate] invalid err got %v | expected %v", err, ErrInvalidContact)
+	}
+
 	for index, tcase := range testCase {
 		mock.ExpectBegin()
-		query := mock.ExpectQuery("(?i)INSERT INTO contacts name, email, phone VALUES (.+)")
+		query := mock.ExpectQuery("(?i)INSERT INTO contacts (.+) VALUES (.+)")
 		if tcase.QueryError {
 			query.WillReturnError(errors.New("error insert"))
 			mock.ExpectRollback()
 		} else if tcase.Rows != nil {
 			query.WillReturnRows(tcase.Rows)
+			mock.ExpectExec("(?i)INSERT INTO outbox").WithArgs("tenant1", topicCreated, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 			mock.ExpectCommit()
 		}
 
-		res, err := pkgCon.Create(tcase.Input)
+		res, err := pkgCon.Create(tenantCtx, tcase.Input)
 		if !reflect.DeepEqual(res, tcase.ExpectedResult) {
 			t.Errorf("[TestCreate] tcase:%v res got %v | expected %v", index, res, tcase.ExpectedResult)
 		}
@@ -175,6 +480,29 @@ func TestCreate(t *testing.T) {
 
 	}
 
+	for index, tcase := range conflictCase {
+		for i := 0; i < tcase.Taken; i++ {
+			mock.ExpectBegin()
+			mock.ExpectQuery("(?i)INSERT INTO contacts (.+) VALUES (.+) ON CONFLICT").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectRollback()
+		}
+		if tcase.ExpectedError == nil {
+			mock.ExpectBegin()
+			mock.ExpectQuery("(?i)INSERT INTO contacts (.+) VALUES (.+)").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+			mock.ExpectExec("(?i)INSERT INTO outbox").WithArgs("tenant1", topicCreated, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+		}
+
+		res, err := pkgCon.Create(tenantCtx, input)
+		if err != tcase.ExpectedError {
+			t.Errorf("[TestCreate] conflict tcase:%v err got %v | expected %v", index, err, tcase.ExpectedError)
+		}
+
+		if !reflect.DeepEqual(res, tcase.ExpectedResult) {
+			t.Errorf("[TestCreate] conflict tcase:%v res got %v | expected %v", index, res, tcase.ExpectedResult)
+		}
+	}
+
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expections: %s", err)
@@ -239,6 +567,21 @@ func TestList(t *testing.T) {
 			[]ContactData{},
 			true,
 		},
+		// the first page is cached, so no query is expected
+		{
+			5,
+			1,
+			nil,
+			false,
+			[]ContactData{
+				ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"},
+				ContactData{ID: 2, Name: "user2", Email: "user2@email.com", Phone: "+628123456788"},
+				ContactData{ID: 3, Name: "user3", Email: "user3@email.com", Phone: "+628123456787"},
+				ContactData{ID: 4, Name: "user4", Email: "user4@email.com", Phone: "+628123456786"},
+				ContactData{ID: 5, Name: "user5", Email: "user5@email.com", Phone: "+628123456785"},
+			},
+			false,
+		},
 	}
 
 	for index, tcase := range testCase {
@@ -248,7 +591,7 @@ func TestList(t *testing.T) {
 			prepared["list"].ExpectQuery().WillReturnRows(tcase.Rows)
 		}
 
-		res, err := pkgCon.List(tcase.Take, tcase.Page)
+		res, err := pkgCon.List(tenantCtx, tcase.Take, tcase.Page)
 
 		if !reflect.DeepEqual(res, tcase.ExpectedResult) {
 			t.Errorf("[TestList] tcase:%v res got %v | expected %v", index, res, tcase.ExpectedResult)
@@ -265,8 +608,38 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestMergeLists(t *testing.T) {
+	lists := [][]ContactData{
+		{{ID: 1}, {ID: 4}, {ID: 7}},
+		{{ID: 2}, {ID: 4}, {ID: 5}},
+		{},
+	}
+
+	testCase := []struct {
+		Take     int64
+		Offset   int64
+		Expected []int64
+	}{
+		{3, 0, []int64{1, 2, 4}},
+		{3, 3, []int64{5, 7}},
+		{2, 5, []int64{}},
+		{10, 0, []int64{1, 2, 4, 5, 7}},
+	}
+
+	for index, tcase := range testCase {
+		got := []int64{}
+		for _, cData := range mergeLists(lists, tcase.Take, tcase.Offset) {
+			got = append(got, cData.ID)
+		}
+
+		if !reflect.DeepEqual(got, tcase.Expected) {
+			t.Errorf("[TestMergeLists] tcase:%v got %v | expected %v", index, got, tcase.Expected)
+		}
+	}
+}
+
 func TestData(t *testing.T) {
-	cObj := &contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:1"}
+	cObj := &contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:tenant1:1", tenantID: "tenant1"}
 	data := cObj.Data()
 	if !reflect.DeepEqual(data, cObj.data) {
 		t.Errorf("[TestData] got %v | expect %v", data, cObj.data)
@@ -274,7 +647,7 @@ func TestData(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	cObj := &contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:1"}
+	cObj := &contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:tenant1:1", tenantID: "tenant1"}
 
 	testCase := []struct {
 		Cobj        Contact
@@ -297,22 +670,72 @@ func TestUpdate(t *testing.T) {
 			false,
 			ContactData{},
 		},
+		{
+			cObj,
+			ContactData{Email: "user1@email"},
+			true,
+			false,
+			ContactData{},
+		},
 	}
 
 	for index, tcase := range testCase {
 
 		if tcase.ExpectQuery {
 			mock.ExpectBegin()
-			mock.ExpectExec("(?i)UPDATE contacts SET (.+) WHERE id (.+)").WithArgs(tcase.QueryArgs.Name, tcase.QueryArgs.Email, tcase.QueryArgs.Phone, tcase.QueryArgs.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("(?i)UPDATE contacts SET (.+) WHERE tenant_id = (.+) AND id = (.+)").WithArgs(tcase.QueryArgs.Name, tcase.QueryArgs.Email, tcase.QueryArgs.Phone, "tenant1", tcase.QueryArgs.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("(?i)INSERT INTO outbox").WithArgs("tenant1", topicUpdated, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 			mock.ExpectCommit()
 		}
 
-		err := cObj.Update(tcase.Input)
+		err := cObj.Update(tenantCtx, tcase.Input)
 
 		// validate expect error
 		if (err != nil && !tcase.ExpectError) || (err == nil && tcase.ExpectError) {
 			t.Errorf("[TestUpdate] tcase:%v err got %v | expected err!=nil->%v", index, err, tcase.ExpectError)
 		}
+
+		// invalid input is told apart from failure
+		if tcase.ExpectError && err != ErrInvalidContact {
+			t.Errorf("[TestUpdate] tcase:%v err got %v | expected %v", index, err, ErrInvalidContact)
+		}
+
+		// updated data is written through to cache
+		if tcase.ExpectQuery {
+			entry, _ := getCachedEntry(tenantCtx, "contact:tenant1:1")
+			if entry.Data == nil || entry.Data.Name != tcase.QueryArgs.Name {
+				t.Errorf("[TestUpdate] tcase:%v cache got %v | expected %v", index, entry.Data, tcase.QueryArgs.Name)
+			}
+		}
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestUpdateNotFound(t *testing.T) {
+	cObj := &contact{data: ContactData{ID: 2, Name: "user2", Email: "user2@email.com", Phone: "+628123456789"}, cacheKey: "contact:tenant1:2", tenantID: "tenant1"}
+	cObj.storeCache(tenantCtx, 0)
+
+	// contact is deleted after it's read
+	mock.ExpectBegin()
+	mock.ExpectExec("(?i)UPDATE contacts SET (.+) WHERE tenant_id = (.+) AND id = (.+)").WithArgs("NewUser2", "user2@email.com", "+628123456789", "tenant1", 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := cObj.Update(tenantCtx, ContactData{Name: "NewUser2"})
+	if err != ErrNotFound {
+		t.Errorf("[TestUpdateNotFound] err got %v | expected %v", err, ErrNotFound)
+	}
+
+	// stale data is dropped instead of written through
+	if entry, cached := getCachedEntry(tenantCtx, "contact:tenant1:2"); cached {
+		t.Errorf("[TestUpdateNotFound] cache got %v | expected miss", entry.Data)
+	}
+
+	if cObj.data.Name != "user2" {
+		t.Errorf("[TestUpdateNotFound] data got %v | expected %v", cObj.data.Name, "user2")
 	}
 
 	// we make sure that all expectations were met
@@ -322,17 +745,60 @@ func TestUpdate(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	cObj := &contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:1"}
+	cObj := &contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789"}, cacheKey: "contact:tenant1:1", tenantID: "tenant1"}
 
 	mock.ExpectBegin()
-	mock.ExpectExec("(?i)DELETE FROM contacts WHERE id =(.+)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("(?i)DELETE FROM contacts WHERE tenant_id = (.+) AND id = (.+)").WithArgs("tenant1", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("(?i)INSERT INTO outbox").WithArgs("tenant1", topicDeleted, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
-	err := cObj.Delete()
+	err := cObj.Delete(tenantCtx)
 	if err != nil {
 		t.Errorf("[TestDelete] fail to delete")
 	}
 }
 
+func TestDeleteLaggingReplica(t *testing.T) {
+	db, replicaMock, _ := sqlmock.New()
+	dbconn := sqlx.NewDb(db, "postgres")
+	database.MockDB(dbconn, []string{"main"})
+	defer database.MockDB(mockDB, []string{"main"})
+
+	for range queryNames {
+		replicaMock.ExpectPrepare(".+")
+	}
+	prepareQueries("main")
+
+	data := ContactData{ID: 9, Name: "user9", Email: "user9@email.com", Phone: "+628123456789", OwnerID: "user1"}
+	cObj := &contact{data: data, cacheKey: "contact:tenant1:9", tenantID: "tenant1"}
+	cObj.storeCache(tenantCtx, 0)
+
+	replicaMock.ExpectBegin()
+	replicaMock.ExpectExec("(?i)DELETE FROM contacts").WithArgs("tenant1", 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	replicaMock.ExpectExec("(?i)INSERT INTO outbox").WithArgs("tenant1", topicDeleted, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	replicaMock.ExpectCommit()
+	if err := cObj.Delete(tenantCtx); err != nil {
+		t.Errorf("[TestDeleteLaggingReplica] delete err got %v | expected nil", err)
+	}
+
+	// replica hasn't replayed the delete yet
+	replicaMock.ExpectQuery("(?i)SELECT (.+) FROM contacts").WithArgs("tenant1", 9).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "phone", "owner_id"}).AddRow(9, "user9", "user9@email.com", "+628123456789", "user1"))
+
+	for i := 0; i < 2; i++ {
+		if res, err := pkgCon.Get(tenantCtx, 9); res != nil || err != nil {
+			t.Errorf("[TestDeleteLaggingReplica] get %v got %v %v | expected nil", i, res, err)
+		}
+	}
+
+	if entry, cached := getCachedEntry(tenantCtx, "contact:tenant1:9"); !cached || entry.Data != nil {
+		t.Errorf("[TestDeleteLaggingReplica] cache got %v %v | expected not found", entry.Data, cached)
+	}
+
+	// lagging replica is never read while the tombstone live
+	if err := replicaMock.ExpectationsWereMet(); err == nil {
+		t.Errorf("[TestDeleteLaggingReplica] replica got read | expected tombstone hit")
+	}
+}
+
 func TestValidate(t *testing.T) {
 	testCase := []struct {
 		Data           ContactData
@@ -367,3 +833,67 @@ func TestValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestAccess(t *testing.T) {
+	cObj := &contact{data: ContactData{ID: 1, Name: "user1", Email: "user1@email.com", Phone: "+628123456789", OwnerID: "owner"}, cacheKey: "contact:tenant1:1", tenantID: "tenant1"}
+
+	testCase := []struct {
+		Principal      *auth.Principal
+		Share          string
+		ExpectedResult int
+	}{
+		{
+			&auth.Principal{Subject: "owner", Roles: []string{RoleEditor}},
+			"",
+			accessOwner,
+		},
+		{
+			&auth.Principal{Subject: "admin", Roles: []string{RoleAdmin}},
+			"",
+			accessOwner,
+		},
+		{
+			&auth.Principal{Subject: "user2", Roles: []string{RoleEditor}},
+			PermissionWrite,
+			accessWrite,
+		},
+		{
+			&auth.Principal{Subject: "user2", Roles: []string{RoleViewer}},
+			PermissionWrite,
+			accessRead,
+		},
+		{
+			&auth.Principal{Subject: "owner", Roles: []string{RoleViewer}},
+			"",
+			accessRead,
+		},
+		{
+			&auth.Principal{Subject: "user3", Roles: []string{RoleEditor}},
+			"none",
+			accessNone,
+		},
+	}
+
+	for index, tcase := range testCase {
+		if tcase.Share == "none" {
+			mock.ExpectQuery("(?i)SELECT permission FROM contact_shares WHERE (.+)").WithArgs("tenant1", 1, tcase.Principal.Subject).WillReturnRows(sqlmock.NewRows([]string{"permission"}))
+		} else if tcase.Share != "" {
+			mock.ExpectQuery("(?i)SELECT permission FROM contact_shares WHERE (.+)").WithArgs("tenant1", 1, tcase.Principal.Subject).WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow(tcase.Share))
+		}
+
+		ctx := auth.WithPrincipal(tenantCtx, tcase.Principal)
+		res, err := cObj.access(ctx)
+		if err != nil {
+			t.Errorf("[TestAccess] tcase:%v err got %v", index, err)
+		}
+
+		if res != tcase.ExpectedResult {
+			t.Errorf("[TestAccess] tcase:%v res got %v | expected %v", index, res, tcase.ExpectedResult)
+		}
+	}
+
+	// we make sure that all expectations were met
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
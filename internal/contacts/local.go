@@ -0,0 +1,157 @@
+package contacts
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/cache"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+
+	"gopkg.in/redis.v5"
+)
+
+const (
+	defaultLocalSize = 10000
+	defaultLocalTTL  = 30 * time.Second
+
+	// invalidationChannel carries cache key of changed contact to every instance
+	invalidationChannel = "contacts:invalidate"
+
+	// pingInterval is how long subscription can be idle before it's checked
+	pingInterval = 5 * time.Second
+)
+
+// subscription is the part of redis pubsub that receive invalidation
+type subscription interface {
+	ReceiveTimeout(time.Duration) (interface{}, error)
+	Ping(...string) error
+}
+
+// localCache is in-process cache in front of redis, nil when it's disabled
+var localCache *cache.LRU
+
+// localTTL is how long contact can stay in localCache
+var localTTL = defaultLocalTTL
+
+// startLocalCache create in-process cache and subscribe to invalidation
+// messages published by other instances
+func startLocalCache() {
+	size := cacheConf.Local.Size
+	if size <= 0 {
+		size = defaultLocalSize
+	}
+
+	localTTL = defaultLocalTTL
+	if cacheConf.Local.TTLSeconds > 0 {
+		localTTL = time.Duration(cacheConf.Local.TTLSeconds) * time.Second
+	}
+
+	// without invalidation messages instances would serve each other's stale data
+	if !cache.SupportsPubSub("main") {
+		logger.Error(context.Background(), "redis conn doesn't support pub/sub, local cache is disabled", "func", "contacts.startLocalCache")
+		return
+	}
+
+	localCache = cache.NewLRU("contact_local", size, localTTL)
+
+	go subscribeInvalidation(localCache)
+}
+
+// subscribeInvalidation remove contact from in-process cache when any instance change it
+// messages published while it's not subscribed are lost, so the cache is purged
+// every time subscription is confirmed and whenever the connection fails,
+// and ttl bounds how long other races can serve stale data
+func subscribeInvalidation(lru *cache.LRU) {
+	ctx := context.Background()
+
+	for {
+		pubsub, err := cache.Subscribe("main", invalidationChannel)
+		if err != nil {
+			lru.Purge()
+			logger.Warn(ctx, "fail subscribe invalidation", "func", "contacts.subscribeInvalidation", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		err = receiveInvalidation(pubsub, lru)
+		logger.Warn(ctx, "fail receive invalidation", "func", "contacts.subscribeInvalidation", "error", err)
+
+		// messages may be lost until it subscribes again
+		lru.Purge()
+		pubsub.Close()
+		time.Sleep(time.Second)
+	}
+}
+
+// receiveInvalidation apply invalidation messages until the subscription fails
+// idle subscription is pinged, so a dead connection isn't mistaken for no change
+func receiveInvalidation(pubsub subscription, lru *cache.LRU) error {
+	for {
+		msg, err := pubsub.ReceiveTimeout(pingInterval)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			if err := pubsub.Ping(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			// connection may be new, anything published before is lost
+			lru.Purge()
+		case *redis.Message:
+			lru.Remove(msg.Payload)
+		}
+	}
+}
+
+// localGet return contact data from in-process cache
+func localGet(cacheKey string) (ContactData, bool) {
+	if localCache == nil {
+		return ContactData{}, false
+	}
+
+	val, ok := localCache.Get(cacheKey)
+	if !ok {
+		return ContactData{}, false
+	}
+
+	return val.(ContactData), true
+}
+
+// localSet store contact data to in-process cache
+func localSet(cacheKey string, cData ContactData) {
+	if localCache != nil {
+		localCache.Set(cacheKey, cData)
+	}
+}
+
+// invalidateLocal remove contact from in-process cache of every instance
+// failed publish is retried until the contact expires from every local cache
+func invalidateLocal(ctx context.Context, cacheKey string) {
+	if localCache == nil {
+		return
+	}
+
+	localCache.Remove(cacheKey)
+
+	err := cache.Publish("main", invalidationChannel, cacheKey)
+	if err == nil {
+		return
+	}
+	logger.Warn(ctx, "fail publish invalidation", "func", "contacts.invalidateLocal", "key", cacheKey, "error", err)
+
+	go func(deadline time.Time) {
+		for time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+			if cache.Publish("main", invalidationChannel, cacheKey) == nil {
+				return
+			}
+		}
+		logger.Error(ctx, "give up publish invalidation", "func", "contacts.invalidateLocal", "key", cacheKey)
+	}(time.Now().Add(localTTL))
+}
@@ -0,0 +1,208 @@
+package contacts
+
+import (
+	"context"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/shard"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type (
+	// movedContact is contact row with every column that is copied to new shard
+	movedContact struct {
+		ID       int64  `db:"id"`
+		TenantID string `db:"tenant_id"`
+		Name     string `db:"name"`
+		Email    string `db:"email"`
+		Phone    string `db:"phone"`
+		OwnerID  string `db:"owner_id"`
+	}
+
+	movedShare struct {
+		TenantID   string `db:"tenant_id"`
+		ContactID  int64  `db:"contact_id"`
+		Grantee    string `db:"grantee"`
+		Permission string `db:"permission"`
+	}
+)
+
+// Reshard move contacts of every shard that has Previous from the previous database
+// to the shard, batch rows at a time, progress is called after every batch
+// contact is copied before it's deleted from the previous database, so it can be
+// stopped anytime and run again, Previous can be removed from config once it's done
+func Reshard(ctx context.Context, batch int, progress func(s config.Shard, scanned, moved int)) error {
+	if !shard.Enabled() {
+		return nil
+	}
+
+	for _, s := range shard.Moving() {
+		from, err := database.Conn(s.Previous, "master")
+		if err != nil {
+			return err
+		}
+
+		to, err := database.Conn(s.Database, "master")
+		if err != nil {
+			return err
+		}
+
+		var last int64
+		for {
+			scanned, moved, next, err := moveBatch(ctx, from, to, s, last, batch)
+			if err != nil {
+				return err
+			}
+			if scanned == 0 {
+				break
+			}
+
+			last = next
+			progress(s, scanned, moved)
+		}
+	}
+
+	return nil
+}
+
+// moveBatch move contacts of the shard among batch rows after id last
+// rows are locked in the previous database until they're copied and deleted,
+// write that waited for the lock find no row there, and is run again on the shard
+// by contact.onHome
+func moveBatch(ctx context.Context, from, to *sqlx.DB, s config.Shard, last int64, batch int) (int, int, int64, error) {
+	fromTx, err := from.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, 0, last, err
+	}
+	defer fromTx.Rollback()
+
+	rows := []movedContact{}
+	err = fromTx.SelectContext(ctx, &rows, `
+		SELECT
+			id, tenant_id, name, email, phone, owner_id
+		FROM
+			contacts
+		WHERE id > $1
+		ORDER BY id ASC
+		LIMIT $2
+		FOR UPDATE
+	`, last, batch)
+	if err != nil || len(rows) == 0 {
+		return 0, 0, last, err
+	}
+
+	moving := []movedContact{}
+	ids := []int64{}
+	for _, row := range rows {
+		// tenant with dedicated database isn't sharded
+		if _, dedicated := tenantDatabases[row.TenantID]; dedicated {
+			continue
+		}
+
+		slot := shard.Slot(row.ID)
+		if slot >= s.From && slot <= s.To {
+			moving = append(moving, row)
+			ids = append(ids, row.ID)
+		}
+	}
+	next := rows[len(rows)-1].ID
+
+	if len(moving) == 0 {
+		return len(rows), 0, next, fromTx.Commit()
+	}
+
+	shares := []movedShare{}
+	err = fromTx.SelectContext(ctx, &shares, `
+		SELECT
+			tenant_id, contact_id, grantee, permission
+		FROM
+			contact_shares
+		WHERE contact_id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return 0, 0, last, err
+	}
+
+	err = copyContacts(ctx, to, moving, shares)
+	if err != nil {
+		return 0, 0, last, err
+	}
+
+	// shares are deleted by cascade
+	_, err = fromTx.ExecContext(ctx, `
+		DELETE FROM
+		contacts
+		WHERE id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return 0, 0, last, err
+	}
+
+	return len(rows), len(moving), next, fromTx.Commit()
+}
+
+// copyContacts upsert contacts and their shares into the shard, row that was
+// copied by a run that stopped before deleting it is overwritten
+func copyContacts(ctx context.Context, to *sqlx.DB, moving []movedContact, shares []movedShare) error {
+	tx, err := to.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, c := range moving {
+		_, err = tx.NamedExecContext(ctx, `
+			INSERT INTO
+			contacts (
+				id,
+				tenant_id,
+				name,
+				email,
+				phone,
+				owner_id
+			) VALUES (
+				:id,
+				:tenant_id,
+				:name,
+				:email,
+				:phone,
+				:owner_id
+			) ON CONFLICT (id)
+			DO UPDATE SET
+				tenant_id = EXCLUDED.tenant_id,
+				name = EXCLUDED.name,
+				email = EXCLUDED.email,
+				phone = EXCLUDED.phone,
+				owner_id = EXCLUDED.owner_id
+		`, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range shares {
+		_, err = tx.NamedExecContext(ctx, `
+			INSERT INTO
+			contact_shares (
+				tenant_id,
+				contact_id,
+				grantee,
+				permission
+			) VALUES (
+				:tenant_id,
+				:contact_id,
+				:grantee,
+				:permission
+			) ON CONFLICT (tenant_id, contact_id, grantee)
+			DO UPDATE SET permission = EXCLUDED.permission
+		`, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,210 @@
+package contacts
+
+import (
+	"testing"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/shard"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// reshardConfig move slot 1 of 2 from main to shard1
+var reshardConfig = config.Sharding{
+	Slots: 2,
+	Shards: []config.Shard{
+		{Database: "main", From: 0, To: 0},
+		{Database: "shard1", From: 1, To: 1, Previous: "main"},
+	},
+}
+
+// idInSlot return the smallest id after last that is hashed to slot
+func idInSlot(slot int, last int64) int64 {
+	id := last + 1
+	for shard.Slot(id) != slot {
+		id++
+	}
+	return id
+}
+
+func TestMoveBatch(t *testing.T) {
+	if err := shard.Configure(reshardConfig); err != nil {
+		t.Fatal(err)
+	}
+	defer shard.Configure(config.Sharding{})
+
+	fromDB, fromMock, _ := sqlmock.New()
+	toDB, toMock, _ := sqlmock.New()
+	from := sqlx.NewDb(fromDB, "postgres")
+	to := sqlx.NewDb(toDB, "postgres")
+
+	moving := idInSlot(1, 0)
+	staying := idInSlot(0, 0)
+	first, last := moving, staying
+	if staying < moving {
+		first, last = staying, moving
+	}
+
+	contactColumns := []string{"id", "tenant_id", "name", "email", "phone", "owner_id"}
+	rows := sqlmock.NewRows(contactColumns)
+	for _, id := range []int64{first, last} {
+		rows.AddRow(id, "tenant1", "user", "user@email.com", "+628123456789", "user1")
+	}
+
+	// rows stay locked in the previous database until they're copied and deleted
+	fromMock.ExpectBegin()
+	fromMock.ExpectQuery("(?i)SELECT (.+) FROM contacts WHERE id > (.+) FOR UPDATE").WithArgs(0, 10).WillReturnRows(rows)
+	fromMock.ExpectQuery("(?i)SELECT (.+) FROM contact_shares WHERE contact_id = ANY").WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "contact_id", "grantee", "permission"}).AddRow("tenant1", moving, "user2", PermissionRead))
+
+	toMock.ExpectBegin()
+	toMock.ExpectExec("(?i)INSERT INTO contacts (.+) ON CONFLICT").WithArgs(moving, "tenant1", "user", "user@email.com", "+628123456789", "user1").WillReturnResult(sqlmock.NewResult(0, 1))
+	toMock.ExpectExec("(?i)INSERT INTO contact_shares (.+) ON CONFLICT").WithArgs("tenant1", moving, "user2", PermissionRead).WillReturnResult(sqlmock.NewResult(0, 1))
+	toMock.ExpectCommit()
+
+	fromMock.ExpectExec("(?i)DELETE FROM contacts WHERE id = ANY").WillReturnResult(sqlmock.NewResult(0, 1))
+	fromMock.ExpectCommit()
+
+	// next batch is empty
+	fromMock.ExpectBegin()
+	fromMock.ExpectQuery("(?i)SELECT (.+) FROM contacts WHERE id > (.+) FOR UPDATE").WithArgs(last, 10).WillReturnRows(sqlmock.NewRows(contactColumns))
+	fromMock.ExpectRollback()
+
+	testCase := []struct {
+		Last            int64
+		ExpectedScanned int
+		ExpectedMoved   int
+		ExpectedNext    int64
+	}{
+		{0, 2, 1, last},
+		{last, 0, 0, last},
+	}
+
+	for index, tcase := range testCase {
+		scanned, moved, next, err := moveBatch(tenantCtx, from, to, reshardConfig.Shards[1], tcase.Last, 10)
+		if err != nil {
+			t.Errorf("[TestMoveBatch] tcase:%v err got %v | expected nil", index, err)
+		}
+
+		if scanned != tcase.ExpectedScanned || moved != tcase.ExpectedMoved || next != tcase.ExpectedNext {
+			t.Errorf("[TestMoveBatch] tcase:%v got %v,%v,%v | expected %v,%v,%v", index, scanned, moved, next, tcase.ExpectedScanned, tcase.ExpectedMoved, tcase.ExpectedNext)
+		}
+	}
+
+	if err := fromMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestMoveBatch] previous database expectation got %v | expected nil", err)
+	}
+	if err := toMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestMoveBatch] shard expectation got %v | expected nil", err)
+	}
+}
+
+func TestWriteMovedContact(t *testing.T) {
+	if err := shard.Configure(reshardConfig); err != nil {
+		t.Fatal(err)
+	}
+	defer shard.Configure(config.Sharding{})
+
+	db, shardMock, _ := sqlmock.New()
+	dbconn := sqlx.NewDb(db, "postgres")
+	database.MockDB(dbconn, []string{"main", "shard1"})
+	defer database.MockDB(mockDB, []string{"main"})
+
+	// statements are prepared before any transaction hold the connection
+	for range queryNames {
+		shardMock.ExpectPrepare(".+")
+	}
+	prepareQueries("main")
+
+	id := idInSlot(1, 100)
+	data := ContactData{ID: id, Name: "user", Email: "user@email.com", Phone: "+628123456789", OwnerID: "user1"}
+	exists := func(found bool) {
+		shardMock.ExpectQuery("(?i)SELECT EXISTS").WithArgs("tenant1", id).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(found))
+	}
+
+	// update waited for the row lock in main, reshard moved the contact meanwhile
+	exists(false)
+	shardMock.ExpectBegin()
+	shardMock.ExpectExec("(?i)UPDATE contacts SET").WithArgs("moved", data.Email, data.Phone, "tenant1", id).WillReturnResult(sqlmock.NewResult(0, 0))
+	shardMock.ExpectRollback()
+	exists(true)
+	shardMock.ExpectBegin()
+	shardMock.ExpectExec("(?i)UPDATE contacts SET").WithArgs("moved", data.Email, data.Phone, "tenant1", id).WillReturnResult(sqlmock.NewResult(0, 1))
+	shardMock.ExpectExec("(?i)INSERT INTO outbox").WithArgs("tenant1", topicUpdated, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	shardMock.ExpectCommit()
+
+	cObj := &contact{data: data, cacheKey: getCacheKey("tenant1", id), tenantID: "tenant1"}
+	if err := cObj.Update(tenantCtx, ContactData{Name: "moved"}); err != nil {
+		t.Errorf("[TestWriteMovedContact] update err got %v | expected nil", err)
+	}
+
+	// delete of contact that is gone from both databases isn't reported as success
+	exists(false)
+	shardMock.ExpectBegin()
+	shardMock.ExpectExec("(?i)DELETE FROM contacts").WithArgs("tenant1", id).WillReturnResult(sqlmock.NewResult(0, 0))
+	shardMock.ExpectRollback()
+	exists(false)
+
+	if err := cObj.Delete(tenantCtx); err != ErrNotFound {
+		t.Errorf("[TestWriteMovedContact] delete err got %v | expected %v", err, ErrNotFound)
+	}
+
+	if err := shardMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestWriteMovedContact] expectation got %v | expected nil", err)
+	}
+}
+
+func TestShareMovedContact(t *testing.T) {
+	if err := shard.Configure(reshardConfig); err != nil {
+		t.Fatal(err)
+	}
+	defer shard.Configure(config.Sharding{})
+
+	db, shardMock, _ := sqlmock.New()
+	dbconn := sqlx.NewDb(db, "postgres")
+	database.MockDB(dbconn, []string{"main", "shard1"})
+	defer database.MockDB(mockDB, []string{"main"})
+
+	for range queryNames {
+		shardMock.ExpectPrepare(".+")
+	}
+	prepareQueries("main")
+
+	id := idInSlot(1, 200)
+	data := ContactData{ID: id, Name: "user", Email: "user@email.com", Phone: "+628123456789", OwnerID: "user1"}
+	exists := func(found bool) {
+		shardMock.ExpectQuery("(?i)SELECT EXISTS").WithArgs("tenant1", id).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(found))
+	}
+
+	// reshard moved the contact before grant is stored in main, it's stored next to the contact
+	exists(false)
+	shardMock.ExpectBegin()
+	shardMock.ExpectExec("(?i)INSERT INTO contact_shares (.+) WHERE EXISTS").WithArgs("tenant1", id, "user2", PermissionRead).WillReturnResult(sqlmock.NewResult(0, 0))
+	shardMock.ExpectRollback()
+	exists(true)
+	shardMock.ExpectBegin()
+	shardMock.ExpectExec("(?i)INSERT INTO contact_shares (.+) WHERE EXISTS").WithArgs("tenant1", id, "user2", PermissionRead).WillReturnResult(sqlmock.NewResult(0, 1))
+	shardMock.ExpectExec("(?i)INSERT INTO outbox").WithArgs("tenant1", topicShared, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	shardMock.ExpectCommit()
+
+	cObj := &contact{data: data, cacheKey: getCacheKey("tenant1", id), tenantID: "tenant1"}
+	if err := cObj.Share(tenantCtx, Share{Grantee: "user2", Permission: PermissionRead}); err != nil {
+		t.Errorf("[TestShareMovedContact] share err got %v | expected nil", err)
+	}
+
+	// revoke that delete nothing isn't reported as success
+	exists(true)
+	shardMock.ExpectBegin()
+	shardMock.ExpectExec("(?i)DELETE FROM contact_shares").WithArgs("tenant1", id, "user3").WillReturnResult(sqlmock.NewResult(0, 0))
+	shardMock.ExpectRollback()
+	exists(true)
+
+	if err := cObj.Unshare(tenantCtx, "user3"); err != ErrNotFound {
+		t.Errorf("[TestShareMovedContact] unshare err got %v | expected %v", err, ErrNotFound)
+	}
+
+	if err := shardMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestShareMovedContact] expectation got %v | expected nil", err)
+	}
+}
@@ -0,0 +1,159 @@
+package contacts
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/database"
+	"github.com/ffjabbari/go-microservice-sample/internal/shard"
+)
+
+type ctxKey int
+
+const tenantKey ctxKey = iota
+
+// ErrNoTenant is returned when contact is accessed without tenant in context
+var ErrNoTenant = errors.New("no tenant in context")
+
+// tenantDatabases maps tenant id to named database,
+// tenant that isn't listed is stored in "main"
+var tenantDatabases map[string]string
+
+// MapTenants is for set which named database holds contacts of a tenant
+func MapTenants(mapping map[string]string) {
+	tenantDatabases = mapping
+}
+
+// WithTenant store tenant id in ctx, every contact operation is scoped to it
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenantID)
+}
+
+// TenantFromContext return tenant id stored in ctx
+func TenantFromContext(ctx context.Context) (string, error) {
+	tenantID, _ := ctx.Value(tenantKey).(string)
+	if tenantID == "" {
+		return "", ErrNoTenant
+	}
+
+	return tenantID, nil
+}
+
+// tenantDB return named database of a tenant
+func tenantDB(tenantID string) string {
+	if dbname, ok := tenantDatabases[tenantID]; ok {
+		return dbname
+	}
+
+	return "main"
+}
+
+// sharded report whether contacts of a tenant are spread across shards,
+// tenant with dedicated database isn't sharded
+func sharded(tenantID string) bool {
+	_, dedicated := tenantDatabases[tenantID]
+	return !dedicated && shard.Enabled()
+}
+
+// contactDBs return database that owns the contact, followed by the one
+// it's being moved from while its shard is resharded
+func contactDBs(tenantID string, contactID int64) []string {
+	if !sharded(tenantID) {
+		return []string{tenantDB(tenantID)}
+	}
+
+	owner, previous := shard.ForID(contactID)
+	if previous == "" || previous == owner {
+		return []string{owner}
+	}
+
+	return []string{owner, previous}
+}
+
+// listDBs return every database that may hold contacts of a tenant
+func listDBs(tenantID string) []string {
+	if !sharded(tenantID) {
+		return []string{tenantDB(tenantID)}
+	}
+
+	return shard.Databases()
+}
+
+// home return database that holds the contact, contact whose shard is being
+// resharded stays in the database it's moved from until reshard copy it
+func (c *contact) home(ctx context.Context) (string, error) {
+	dbs := contactDBs(c.tenantID, c.data.ID)
+	if len(dbs) == 1 {
+		return dbs[0], nil
+	}
+
+	dbconn, err := database.Queryer(ctx, dbs[0], "master")
+	if err != nil {
+		return "", err
+	}
+
+	var moved bool
+	err = dbconn.QueryRowxContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM contacts
+			WHERE tenant_id = $1
+				AND id = $2
+		)
+	`, c.tenantID, c.data.ID).Scan(&moved)
+	if err != nil {
+		return "", err
+	}
+
+	if moved {
+		return dbs[0], nil
+	}
+
+	return dbs[1], nil
+}
+
+// onHome run write on database that holds the contact, write that find the contact
+// gone from there is run again when it's moved meanwhile, reshard may have copied it
+// to its shard while the write waited for the row lock
+// ErrNotFound is returned when no database it may be in holds it
+func (c *contact) onHome(ctx context.Context, write func(ctx context.Context, dbname string) error) error {
+	tried := make(map[string]bool)
+	for {
+		dbname, err := c.home(ctx)
+		if err != nil {
+			return err
+		}
+		if tried[dbname] {
+			return ErrNotFound
+		}
+
+		err = write(ctx, dbname)
+		if err != ErrNotFound {
+			return err
+		}
+		tried[dbname] = true
+	}
+}
+
+// inTx report whether ctx holds transaction on any of dbs, read in it must see
+// its uncommitted writes, so it bypass caches and never fill them
+func inTx(ctx context.Context, dbs []string) bool {
+	for _, dbname := range dbs {
+		if database.InTx(ctx, dbname) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// consistent report whether read from any of dbs must see writes of the session
+func consistent(ctx context.Context, dbs []string) bool {
+	for _, dbname := range dbs {
+		if database.Consistent(ctx, dbname) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	defaultMaxOpenConns = 10
+	defaultMaxIdleConns = 3
+
+	// connectAttempts is how many times database is tried at start before giving up,
+	// lazy database is retried until it answers
+	connectAttempts = 5
+
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// open create connection pool of one master or replica
+// pool doesn't dial until it's used, so database is pinged here: at start with a few
+// retries unless it's lazy, in background until it answers when it's lazy
+// reachable is called once it answers, it may be nil
+func open(dbname, replication, dsn string, conf *config.Database, reachable func()) *sqlx.DB {
+	db, err := sqlx.Open("postgres", withConnectTimeout(dsn, conf.ConnectTimeoutSeconds))
+	if err != nil {
+		logger.Fatal(context.Background(), "fail open database", "database", dbname, "replication", replication, "error", err)
+	}
+
+	configurePool(db, conf.Pool)
+
+	if reachable == nil {
+		reachable = func() {}
+	}
+
+	if conf.Lazy {
+		go func() {
+			// it never gives up, so it only returns once database answers
+			waitReachable(db, dbname, replication, 0)
+			reachable()
+		}()
+		return db
+	}
+
+	if err := waitReachable(db, dbname, replication, connectAttempts); err != nil {
+		logger.Fatal(context.Background(), "fail connect to database", "database", dbname, "replication", replication, "error", err)
+	}
+	reachable()
+
+	return db
+}
+
+// configurePool apply pool config, zero value keep the default
+func configurePool(db *sqlx.DB, pool config.Pool) {
+	maxOpen := pool.MaxOpenConns
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenConns
+	}
+
+	maxIdle := pool.MaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdleConns
+	}
+
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(time.Duration(pool.ConnMaxLifetimeSeconds) * time.Second)
+	db.SetConnMaxIdleTime(time.Duration(pool.ConnMaxIdleTimeSeconds) * time.Second)
+}
+
+// waitReachable ping database with backoff until it answers,
+// attempts 0 means it never gives up
+func waitReachable(db *sqlx.DB, dbname, replication string, attempts int) error {
+	var err error
+	for attempt := 0; attempts == 0 || attempt < attempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			if attempt > 0 {
+				logger.Info(context.Background(), "database is reachable", "database", dbname, "replication", replication, "attempt", attempt+1)
+			}
+			return nil
+		}
+
+		wait := backoff(attempt)
+		logger.Warn(context.Background(), "database is unreachable, retrying", "database", dbname, "replication", replication, "attempt", attempt+1, "retry_in", wait.String(), "error", err)
+		time.Sleep(wait)
+	}
+
+	return err
+}
+
+// backoff return exponential wait before retry with full jitter
+func backoff(attempt int) time.Duration {
+	wait := maxBackoff
+	if attempt < 16 {
+		wait = minBackoff << uint(attempt)
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+
+	return time.Duration(rand.Int63n(int64(wait))) + minBackoff/2
+}
+
+// withConnectTimeout add connect_timeout of lib/pq to dsn, unless it's already set
+// dsn is either url or key=value form
+func withConnectTimeout(dsn string, seconds int64) string {
+	if seconds <= 0 || strings.Contains(dsn, "connect_timeout") {
+		return dsn
+	}
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return dsn
+		}
+
+		q := u.Query()
+		q.Set("connect_timeout", fmt.Sprint(seconds))
+		u.RawQuery = q.Encode()
+
+		return u.String()
+	}
+
+	return fmt.Sprintf("%s connect_timeout=%d", dsn, seconds)
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithConnectTimeout(t *testing.T) {
+	testCase := []struct {
+		DSN      string
+		Seconds  int64
+		Expected string
+	}{
+		{"host=localhost dbname=appdb", 0, "host=localhost dbname=appdb"},
+		{"host=localhost dbname=appdb", 5, "host=localhost dbname=appdb connect_timeout=5"},
+		{"host=localhost connect_timeout=2", 5, "host=localhost connect_timeout=2"},
+		{"postgres://user@localhost/appdb?sslmode=disable", 5, "postgres://user@localhost/appdb?connect_timeout=5&sslmode=disable"},
+	}
+
+	for index, tcase := range testCase {
+		if got := withConnectTimeout(tcase.DSN, tcase.Seconds); got != tcase.Expected {
+			t.Errorf("[TestWithConnectTimeout] tcase:%v got %v | expected %v", index, got, tcase.Expected)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	testCase := []struct {
+		Attempt     int
+		ExpectedMax time.Duration
+	}{
+		{0, minBackoff + minBackoff/2},
+		{3, 8*minBackoff + minBackoff/2},
+		{10, maxBackoff + minBackoff/2},
+		{100, maxBackoff + minBackoff/2},
+	}
+
+	for index, tcase := range testCase {
+		for i := 0; i < 100; i++ {
+			got := backoff(tcase.Attempt)
+			if got < minBackoff/2 || got > tcase.ExpectedMax {
+				t.Errorf("[TestBackoff] tcase:%v got %v | expected between %v and %v", index, got, minBackoff/2, tcase.ExpectedMax)
+				break
+			}
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	MockDB(newMockDB(t), []string{"main"})
+
+	testCase := []struct {
+		DB       string
+		Expected bool
+	}{
+		{"main", true},
+		// unknown database never gets ready
+		{"other", false},
+	}
+
+	for index, tcase := range testCase {
+		ready := false
+		select {
+		case <-Ready(tcase.DB):
+			ready = true
+		default:
+		}
+
+		if ready != tcase.Expected {
+			t.Errorf("[TestReady] tcase:%v got %v | expected %v", index, ready, tcase.Expected)
+		}
+	}
+}
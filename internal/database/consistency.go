@@ -0,0 +1,276 @@
+package database
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// catchUpWait is how long reads of a request wait in total for replica to replay
+	// session writes before they go to master
+	catchUpWait = 50 * time.Millisecond
+	catchUpPoll = 10 * time.Millisecond
+)
+
+type (
+	// session remember master wal position of writes a client has seen, per db name
+	// reads in the session are served by replica that has replayed them, or by master
+	session struct {
+		sync.Mutex
+		positions map[string]uint64
+		written   bool
+
+		// principal the token is bound to, token of other principal is ignored
+		principal string
+
+		// deadline is when reads of the session stop waiting for replica
+		deadline time.Time
+	}
+
+	sessionKey struct{}
+)
+
+// tokenSecret sign session tokens, so client can't make reads wait for positions it never wrote
+var tokenSecret []byte
+
+// ConfigureConsistency set secret that sign session tokens
+func ConfigureConsistency(conf config.Consistency) error {
+	secret := conf.Secret
+	if secret == "" && conf.SecretFile != "" {
+		fileByte, err := ioutil.ReadFile(conf.SecretFile)
+		if err != nil {
+			return err
+		}
+		secret = strings.TrimSpace(string(fileByte))
+	}
+
+	if secret == "" {
+		return errors.New("consistency secret is required to sign session token")
+	}
+
+	tokenSecret = []byte(secret)
+	return nil
+}
+
+// WithSession start consistency session of principal from token returned by earlier write,
+// invalid or empty token, and token issued to other principal, start empty session
+func WithSession(ctx context.Context, token, principal string) context.Context {
+	s := &session{positions: make(map[string]uint64), principal: principal}
+
+	payload, ok := verifyToken(token, principal)
+	if !ok {
+		return context.WithValue(ctx, sessionKey{}, s)
+	}
+
+	for _, part := range strings.Split(payload, ",") {
+		i := strings.LastIndex(part, "@")
+		if i <= 0 {
+			continue
+		}
+
+		pos, err := parseLSN(part[i+1:])
+		if err != nil {
+			continue
+		}
+		s.positions[part[:i]] = pos
+	}
+
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// SessionToken return token to be sent back by client, so its next reads see its writes
+// it's empty when nothing is written in the session
+func SessionToken(ctx context.Context) string {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok {
+		return ""
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.written {
+		return ""
+	}
+
+	parts := make([]string, 0, len(s.positions))
+	for dbname, pos := range s.positions {
+		parts = append(parts, dbname+"@"+formatLSN(pos))
+	}
+	sort.Strings(parts)
+
+	payload := strings.Join(parts, ",")
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signToken(payload, s.principal))
+}
+
+// signToken return mac of token payload for the principal
+func signToken(payload, principal string) []byte {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte(principal))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verifyToken return payload of token that is signed for the principal
+func verifyToken(token, principal string) (string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return "", false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return "", false
+	}
+
+	payload := token[:i]
+	return payload, hmac.Equal(sig, signToken(payload, principal))
+}
+
+// Written record master wal position after write of the session is committed
+func Written(ctx context.Context, dbname string) {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok {
+		return
+	}
+
+	dbconn, err := Conn(dbname, "master")
+	if err != nil {
+		return
+	}
+
+	var lsn string
+	err = dbconn.QueryRowxContext(ctx, `SELECT pg_current_wal_lsn()::text`).Scan(&lsn)
+	if err != nil {
+		logger.Warn(ctx, "fail get wal position", "func", "database.Written", "database", dbname, "error", err)
+		return
+	}
+
+	pos, err := parseLSN(lsn)
+	if err != nil {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if pos > s.positions[dbname] {
+		s.positions[dbname] = pos
+	}
+	s.written = true
+}
+
+// Consistent report whether reads of dbname must see writes of the session
+func Consistent(ctx context.Context, dbname string) bool {
+	return sessionPosition(ctx, dbname) != 0
+}
+
+// ReadConn return replica for read, when the session has written to dbname
+// it's replica that has replayed the writes, or master when none catch up in time
+func ReadConn(ctx context.Context, dbname string) (*sqlx.DB, error) {
+	want := sessionPosition(ctx, dbname)
+	if want == 0 {
+		return Conn(dbname, "slave")
+	}
+
+	master, err := Conn(dbname, "master")
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := catchUpDeadline(ctx)
+	for {
+		slave, err := Conn(dbname, "slave")
+		if err != nil || slave == master {
+			return master, nil
+		}
+
+		replayed, err := replayPosition(ctx, slave)
+		if err == nil && replayed >= want {
+			return slave, nil
+		}
+
+		if time.Now().Add(catchUpPoll).After(deadline) {
+			return master, nil
+		}
+		time.Sleep(catchUpPoll)
+	}
+}
+
+// catchUpDeadline return when reads of the session stop waiting for replica
+// every read share one wait, so request that read several databases doesn't wait for each
+func catchUpDeadline(ctx context.Context) time.Time {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok {
+		return time.Now()
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.deadline.IsZero() {
+		s.deadline = time.Now().Add(catchUpWait)
+	}
+
+	return s.deadline
+}
+
+func sessionPosition(ctx context.Context, dbname string) uint64 {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	if !ok {
+		return 0
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.positions[dbname]
+}
+
+// replayPosition return wal position replayed by replica
+// server that isn't in recovery is primary, so it has every write
+func replayPosition(ctx context.Context, db *sqlx.DB) (uint64, error) {
+	var lsn sql.NullString
+	err := db.QueryRowxContext(ctx, `SELECT pg_last_wal_replay_lsn()::text`).Scan(&lsn)
+	if err != nil {
+		return 0, err
+	}
+
+	if !lsn.Valid {
+		return math.MaxUint64, nil
+	}
+
+	return parseLSN(lsn.String)
+}
+
+// parseLSN convert postgres pg_lsn text like 16/B374D848 to number
+func parseLSN(lsn string) (uint64, error) {
+	var hi, lo uint32
+	_, err := fmt.Sscanf(lsn, "%X/%X", &hi, &lo)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lsn %q", lsn)
+	}
+
+	return uint64(hi)<<32 | uint64(lo), nil
+}
+
+func formatLSN(pos uint64) string {
+	return fmt.Sprintf("%X/%X", uint32(pos>>32), uint32(pos))
+}
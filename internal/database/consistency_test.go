@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func signed(payload, principal string) string {
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signToken(payload, principal))
+}
+
+func TestWithSession(t *testing.T) {
+	tokenSecret = []byte("secret")
+
+	testCase := []struct {
+		Token    string
+		DB       string
+		Expected uint64
+	}{
+		{"", "main", 0},
+		{signed("main@16/B374D848", "tenant1:user1"), "main", 0x16B374D848},
+		{signed("main@16/B374D848", "tenant1:user1"), "other", 0},
+		{signed("main@0/1,other@1/0", "tenant1:user1"), "other", 1 << 32},
+		// invalid token is ignored
+		{signed("main@nope", "tenant1:user1"), "main", 0},
+		{signed("16/B374D848", "tenant1:user1"), "main", 0},
+		// unsigned, tampered and other principal's token is ignored
+		{"main@16/B374D848", "main", 0},
+		{strings.Replace(signed("main@0/1", "tenant1:user1"), "0/1", "FF/1", 1), "main", 0},
+		{signed("main@16/B374D848", "tenant1:user2"), "main", 0},
+	}
+
+	for index, tcase := range testCase {
+		ctx := WithSession(context.Background(), tcase.Token, "tenant1:user1")
+		if got := sessionPosition(ctx, tcase.DB); got != tcase.Expected {
+			t.Errorf("[TestWithSession] tcase:%v got %X | expected %X", index, got, tcase.Expected)
+		}
+
+		// nothing is written yet
+		if token := SessionToken(ctx); token != "" {
+			t.Errorf("[TestWithSession] tcase:%v token got %v | expected empty", index, token)
+		}
+	}
+}
+
+func TestConfigureConsistency(t *testing.T) {
+	file, err := ioutil.TempFile("", "consistency-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("file-secret\n")
+	file.Close()
+
+	testCase := []struct {
+		Conf           config.Consistency
+		ExpectedSecret string
+		ExpectError    bool
+	}{
+		{config.Consistency{Secret: "secret"}, "secret", false},
+		{config.Consistency{SecretFile: file.Name()}, "file-secret", false},
+		{config.Consistency{SecretFile: file.Name() + ".missing"}, "", true},
+		{config.Consistency{}, "", true},
+	}
+
+	for index, tcase := range testCase {
+		tokenSecret = nil
+		err := ConfigureConsistency(tcase.Conf)
+		if (err != nil) != tcase.ExpectError || string(tokenSecret) != tcase.ExpectedSecret {
+			t.Errorf("[TestConfigureConsistency] tcase:%v got %q %v | expected %q err!=nil->%v", index, tokenSecret, err, tcase.ExpectedSecret, tcase.ExpectError)
+		}
+	}
+
+	tokenSecret = []byte("secret")
+}
+
+func TestReadConn(t *testing.T) {
+	masterDB, masterMock, _ := sqlmock.New()
+	slaveDB, slaveMock, _ := sqlmock.New()
+	master := sqlx.NewDb(masterDB, "postgres")
+	slave := sqlx.NewDb(slaveDB, "postgres")
+	databases = map[string]databaseReplication{
+		"main": {Master: master, Slaves: newReplicaSet("main", "", []*sqlx.DB{slave})},
+	}
+
+	// client without token read replica
+	if got, _ := ReadConn(context.Background(), "main"); got != slave {
+		t.Errorf("[TestReadConn] no session got %p | expected slave %p", got, slave)
+	}
+
+	tokenSecret = []byte("secret")
+	ctx := WithSession(context.Background(), "", "tenant1:user1")
+	masterMock.ExpectQuery(`SELECT pg_current_wal_lsn`).WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow("0/300"))
+	Written(ctx, "main")
+
+	token := SessionToken(ctx)
+	if token != signed("main@0/300", "tenant1:user1") {
+		t.Errorf("[TestReadConn] token got %v | expected signed main@0/300", token)
+	}
+
+	testCase := []struct {
+		Replayed []interface{}
+		Expected *sqlx.DB
+	}{
+		// replica caught up
+		{[]interface{}{"0/300"}, slave},
+		// replica catch up while read is waiting
+		{[]interface{}{"0/200", "0/400"}, slave},
+		// replica that isn't in recovery has every write
+		{[]interface{}{nil}, slave},
+		// replica keep lagging
+		{[]interface{}{"0/100", "0/100", "0/100", "0/100", "0/100", "0/100"}, master},
+	}
+
+	for index, tcase := range testCase {
+		for _, lsn := range tcase.Replayed {
+			slaveMock.ExpectQuery(`SELECT pg_last_wal_replay_lsn`).WillReturnRows(sqlmock.NewRows([]string{"lsn"}).AddRow(lsn))
+		}
+
+		// every request start its session from the token
+		got, err := ReadConn(WithSession(context.Background(), token, "tenant1:user1"), "main")
+		if err != nil || got != tcase.Expected {
+			t.Errorf("[TestReadConn] tcase:%v got %p %v | expected %p", index, got, err, tcase.Expected)
+		}
+	}
+}
+
+func TestCatchUpDeadline(t *testing.T) {
+	ctx := WithSession(context.Background(), "", "tenant1:user1")
+
+	// reads of one request share the wait
+	first := catchUpDeadline(ctx)
+	time.Sleep(time.Millisecond)
+	if second := catchUpDeadline(ctx); !second.Equal(first) {
+		t.Errorf("[TestCatchUpDeadline] second read got %v | expected %v", second, first)
+	}
+
+	if wait := time.Until(first); wait > catchUpWait {
+		t.Errorf("[TestCatchUpDeadline] wait got %v | expected <= %v", wait, catchUpWait)
+	}
+}
+
+func TestLSN(t *testing.T) {
+	testCase := []string{"0/0", "0/1", "16/B374D848", "FFFFFFFF/FFFFFFFF"}
+
+	for index, lsn := range testCase {
+		pos, err := parseLSN(lsn)
+		if err != nil || formatLSN(pos) != lsn {
+			t.Errorf("[TestLSN] tcase:%v got %v %v | expected %v", index, formatLSN(pos), err, lsn)
+		}
+	}
+
+	if _, err := parseLSN("nope"); err == nil {
+		t.Errorf("[TestLSN] invalid lsn got nil | expected error")
+	}
+}
@@ -1,8 +1,12 @@
 package database
 
 import (
+	"database/sql"
 	"fmt"
-	"log"
+	"sort"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
 
 	_ "github.com/lib/pq"
 
@@ -12,62 +16,129 @@ import (
 type (
 	databaseReplication struct {
 		Master *sqlx.DB
-		Slave  *sqlx.DB
+		Slaves *replicaSet
+
+		// ready is closed once master answers, lazy master may not answer at start
+		ready chan struct{}
 	}
 )
 
 var databases map[string]databaseReplication
 
 // ConnectDB for create db connection
-func ConnectDB(config interface{}) {
-	cfg := config.(map[string]*struct {
-		Master string
-		Slave  string
-	})
+func ConnectDB(cfg map[string]*config.Database) {
 
 	// create map for store all db conn
 	databases = make(map[string]databaseReplication)
 
 	for dbname, conn := range cfg {
-		// connect to master DB
-		dbmaster, err := sqlx.Connect("postgres", conn.Master)
-		if err != nil {
-			log.Fatalln(err)
+		ready := make(chan struct{})
+		dbmaster := open(dbname, "master", conn.Master, conn, func() { close(ready) })
+
+		// old single slave config is the first replica
+		dsns := conn.Slaves
+		if conn.Slave != "" {
+			dsns = append([]string{conn.Slave}, dsns...)
 		}
-		// these are just my number for limit db open conn
-		dbmaster.SetMaxIdleConns(3)
-		dbmaster.SetMaxOpenConns(10)
-
-		// connect to slave DB
-		dbslave, err := sqlx.Connect("postgres", conn.Slave)
-		if err != nil {
-			log.Fatalln(err)
+
+		var dbslaves []*sqlx.DB
+		for _, dsn := range dsns {
+			dbslaves = append(dbslaves, open(dbname, "slave", dsn, conn, nil))
 		}
-		// these are just my number for limit db open conn
-		dbslave.SetMaxIdleConns(3)
-		dbslave.SetMaxOpenConns(10)
+
+		slaves := newReplicaSet(dbname, conn.Balancer, dbslaves)
+		slaves.maxLag = time.Duration(conn.MaxLagMs) * time.Millisecond
+		go slaves.healthCheck(time.Duration(conn.HealthCheckSeconds) * time.Second)
 
 		// assign db conn to struct
 		// assign struct to map
 		databases[dbname] = databaseReplication{
 			Master: dbmaster,
-			Slave:  dbslave,
+			Slaves: slaves,
+			ready:  ready,
 		}
 	}
 }
 
+// Ready return channel that is closed once master of dbname answers
+// it's closed at start unless the database is lazy
+func Ready(dbname string) <-chan struct{} {
+	if dbconn, ok := databases[dbname]; ok {
+		return dbconn.ready
+	}
+
+	// unknown database never gets ready
+	return make(chan struct{})
+}
+
 // Conn is for get database connection
+// slave is one of healthy replicas that don't lag behind,
+// master is returned when there's no such replica
 func Conn(dbname, replication string) (*sqlx.DB, error) {
 	if dbconn, ok := databases[dbname]; ok {
 		if replication == "master" {
 			return dbconn.Master, nil
 		}
-		return dbconn.Slave, nil
+
+		if slave := dbconn.Slaves.pick(); slave != nil {
+			return slave, nil
+		}
+		return dbconn.Master, nil
 	}
 
 	return nil, fmt.Errorf("database %s not found", dbname)
 }
 
+// Names is for list name of all db conn, sorted
+func Names() []string {
+	names := make([]string, 0, len(databases))
+	for dbname := range databases {
+		names = append(names, dbname)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Stats is for get pool statistics of all db conn
+// result is grouped by db name then by replication
+func Stats() map[string]map[string]sql.DBStats {
+	result := make(map[string]map[string]sql.DBStats)
+	for dbname, dbconn := range databases {
+		result[dbname] = map[string]sql.DBStats{
+			"master": dbconn.Master.Stats(),
+		}
+		for _, r := range dbconn.Slaves.replicas {
+			result[dbname][r.name] = r.db.Stats()
+		}
+	}
+
+	return result
+}
+
+// Health is for report state of every replica, keyed by db name and replication
+// state is "up", "down" or "lagging"
+func Health() map[string]string {
+	result := make(map[string]string)
+	for dbname, dbconn := range databases {
+		for _, r := range dbconn.Slaves.replicas {
+			result[dbname+":"+r.name] = dbconn.Slaves.state(r)
+		}
+	}
+
+	return result
+}
+
+// MaxLag return replication lag above which replica of dbname isn't read,
+// 0 when it's unlimited or dbname has no replica
+func MaxLag(dbname string) time.Duration {
+	if dbconn, ok := databases[dbname]; ok && dbconn.Slaves != nil {
+		return dbconn.Slaves.maxLag
+	}
+
+	return 0
+}
+
 // MockDB is for unit testing that require mocking DB
 func MockDB(mockdb *sqlx.DB, replications []string) error {
 
@@ -77,9 +148,13 @@ func MockDB(mockdb *sqlx.DB, replications []string) error {
 
 	databases = make(map[string]databaseReplication)
 	for _, repl := range replications {
+		ready := make(chan struct{})
+		close(ready)
+
 		databases[repl] = databaseReplication{
 			Master: mockdb,
-			Slave:  mockdb,
+			Slaves: newReplicaSet(repl, "", []*sqlx.DB{mockdb}),
+			ready:  ready,
 		}
 	}
 
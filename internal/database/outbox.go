@@ -0,0 +1,32 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AppendOutbox store event in outbox table within tx, so the event exist if and only if
+// the change it describes is committed, publishing it is left to outbox relay
+func AppendOutbox(ctx context.Context, tx *sqlx.Tx, tenantID, topic string, payload interface{}) error {
+	payloadByte, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO
+		outbox (
+			tenant_id,
+			topic,
+			payload
+		) VALUES (
+			$1,
+			$2,
+			$3
+		)
+	`, tenantID, topic, payloadByte)
+
+	return err
+}
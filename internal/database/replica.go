@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	balancerRoundRobin = "round_robin"
+	balancerLeastConn  = "least_conn"
+
+	defaultHealthCheck = 5 * time.Second
+	pingTimeout        = 2 * time.Second
+)
+
+type (
+	// replicaSet spread reads over replicas that pass health check
+	// and don't lag behind more than maxLag
+	replicaSet struct {
+		dbname   string
+		balancer string
+		maxLag   time.Duration
+		replicas []*replica
+		next     uint64
+	}
+
+	replica struct {
+		name string
+		db   *sqlx.DB
+		// down is 1 while the last ping failed
+		down int32
+		// lag is replication lag in nanoseconds measured by the last check
+		lag int64
+		// unmeasured is 1 while the last lag measurement failed
+		unmeasured int32
+	}
+)
+
+// errReceiverStopped is returned when replica reports its wal receiver isn't streaming,
+// its replay stop moving, so it can't be told apart from replica of idle master
+var errReceiverStopped = errors.New("wal receiver isn't streaming")
+
+func newReplicaSet(dbname, balancer string, dbs []*sqlx.DB) *replicaSet {
+	if balancer == "" {
+		balancer = balancerRoundRobin
+	}
+
+	rs := &replicaSet{dbname: dbname, balancer: balancer}
+	for i, db := range dbs {
+		name := "slave"
+		if len(dbs) > 1 {
+			name = fmt.Sprintf("slave%d", i)
+		}
+		rs.replicas = append(rs.replicas, &replica{name: name, db: db})
+	}
+
+	return rs
+}
+
+// pick return healthy replica chosen by balancer, nil when none is healthy
+func (rs *replicaSet) pick() *sqlx.DB {
+	n := len(rs.replicas)
+	if n == 0 {
+		return nil
+	}
+
+	// start from different replica every call, so ties of least_conn are spread too
+	start := int(atomic.AddUint64(&rs.next, 1) % uint64(n))
+
+	var chosen *replica
+	for i := 0; i < n; i++ {
+		r := rs.replicas[(start+i)%n]
+		if rs.state(r) != "up" {
+			continue
+		}
+
+		if rs.balancer != balancerLeastConn {
+			return r.db
+		}
+
+		if chosen == nil || r.db.Stats().InUse < chosen.db.Stats().InUse {
+			chosen = r
+		}
+	}
+
+	if chosen == nil {
+		return nil
+	}
+
+	return chosen.db
+}
+
+// healthCheck ping every replica each interval until the process exit
+func (rs *replicaSet) healthCheck(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultHealthCheck
+	}
+
+	// the first check isn't delayed, so lazy replica that isn't reachable is skipped soon
+	for {
+		for _, r := range rs.replicas {
+			rs.check(r)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (rs *replicaSet) check(r *replica) {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	err := r.db.PingContext(ctx)
+	if err != nil {
+		if atomic.SwapInt32(&r.down, 1) == 0 {
+			logger.Warn(ctx, "replica is down", "database", rs.dbname, "replication", r.name, "error", err)
+
+			// server that comes back may be other one, like promoted standby
+			statements.invalidate(r.db)
+		}
+		return
+	}
+
+	if atomic.SwapInt32(&r.down, 0) == 1 {
+		logger.Info(ctx, "replica is up", "database", rs.dbname, "replication", r.name)
+	}
+
+	// replica whose lag isn't known may serve arbitrarily old data, so it isn't used
+	lag, err := replicationLag(ctx, r.db)
+	if err != nil {
+		if atomic.SwapInt32(&r.unmeasured, 1) == 0 {
+			logger.Warn(ctx, "fail measure replica lag", "database", rs.dbname, "replication", r.name, "error", err)
+		}
+		return
+	}
+
+	if atomic.SwapInt32(&r.unmeasured, 0) == 1 {
+		logger.Info(ctx, "replica lag is measured again", "database", rs.dbname, "replication", r.name)
+	}
+
+	atomic.StoreInt64(&r.lag, int64(lag))
+	metrics.ReplicaLag(rs.dbname, r.name, lag)
+}
+
+// state is "down" when replica doesn't answer, "lagging" when it's too far behind
+// or its lag can't be measured
+func (rs *replicaSet) state(r *replica) string {
+	if atomic.LoadInt32(&r.down) == 1 {
+		return "down"
+	}
+
+	if atomic.LoadInt32(&r.unmeasured) == 1 {
+		return "lagging"
+	}
+
+	if rs.maxLag > 0 && time.Duration(atomic.LoadInt64(&r.lag)) > rs.maxLag {
+		return "lagging"
+	}
+
+	return "up"
+}
+
+// replicationLag return age of the last transaction replayed by replica
+// replica that has replayed everything it received isn't lagging, even when master
+// has been idle since the last transaction
+// status of wal receiver is NULL for role without pg_read_all_stats, or when receiver
+// isn't running, it's unknown then and lag alone is trusted, replica that reports
+// its receiver isn't streaming isn't measured, it may be stuck far behind
+func replicationLag(ctx context.Context, db *sqlx.DB) (time.Duration, error) {
+	var row struct {
+		InRecovery bool            `db:"in_recovery"`
+		Status     sql.NullString  `db:"status"`
+		CaughtUp   bool            `db:"caught_up"`
+		Seconds    sql.NullFloat64 `db:"seconds"`
+	}
+
+	err := db.QueryRowxContext(ctx, `
+		SELECT
+			pg_is_in_recovery() AS in_recovery,
+			(SELECT status FROM pg_stat_wal_receiver LIMIT 1) AS status,
+			COALESCE(pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(), false) AS caught_up,
+			EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) AS seconds
+	`).StructScan(&row)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case !row.InRecovery:
+		return 0, nil
+	case row.Status.Valid && row.Status.String != "streaming":
+		return 0, errReceiverStopped
+	case row.CaughtUp:
+		return 0, nil
+	case !row.Seconds.Valid:
+		return 0, errors.New("no transaction is replayed yet")
+	}
+
+	return time.Duration(row.Seconds.Float64 * float64(time.Second)), nil
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func newMockDB(t *testing.T) *sqlx.DB {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sqlx.NewDb(db, "postgres")
+}
+
+func TestConn(t *testing.T) {
+	master := newMockDB(t)
+	slave0 := newMockDB(t)
+	slave1 := newMockDB(t)
+
+	testCase := []struct {
+		Balancer string
+		Down     []int32
+		Expected []*sqlx.DB
+	}{
+		// reads alternate between replicas
+		{"round_robin", []int32{0, 0}, []*sqlx.DB{slave1, slave0, slave1, slave0}},
+		{"least_conn", []int32{0, 0}, []*sqlx.DB{slave1, slave0, slave1, slave0}},
+		// failing replica is skipped
+		{"round_robin", []int32{1, 0}, []*sqlx.DB{slave1, slave1, slave1}},
+		{"least_conn", []int32{0, 1}, []*sqlx.DB{slave0, slave0, slave0}},
+		// master serve reads when every replica fail
+		{"round_robin", []int32{1, 1}, []*sqlx.DB{master, master}},
+	}
+
+	for index, tcase := range testCase {
+		slaves := newReplicaSet("main", tcase.Balancer, []*sqlx.DB{slave0, slave1})
+		for i, down := range tcase.Down {
+			slaves.replicas[i].down = down
+		}
+		databases = map[string]databaseReplication{"main": {Master: master, Slaves: slaves}}
+
+		for i, expected := range tcase.Expected {
+			got, err := Conn("main", "slave")
+			if err != nil || got != expected {
+				t.Errorf("[TestConn] tcase:%v call:%v got %p %v | expected %p", index, i, got, err, expected)
+			}
+		}
+
+		if got, _ := Conn("main", "master"); got != master {
+			t.Errorf("[TestConn] tcase:%v master got %p | expected %p", index, got, master)
+		}
+
+		health := Health()
+		if (health["main:slave0"] == "down") != (tcase.Down[0] == 1) {
+			t.Errorf("[TestConn] tcase:%v health got %v | expected down %v", index, health, tcase.Down)
+		}
+	}
+
+	if _, err := Conn("other", "slave"); err == nil {
+		t.Errorf("[TestConn] unknown database got nil | expected error")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := newMockDB(t)
+	slave := sqlx.NewDb(db, "postgres")
+
+	slaves := newReplicaSet("main", "", []*sqlx.DB{slave})
+	slaves.maxLag = time.Second
+	databases = map[string]databaseReplication{"main": {Master: master, Slaves: slaves}}
+
+	lagColumns := []string{"in_recovery", "status", "caught_up", "seconds"}
+
+	testCase := []struct {
+		Row           []driver.Value
+		QueryError    error
+		ExpectedState string
+		ExpectedConn  *sqlx.DB
+	}{
+		{[]driver.Value{true, "streaming", true, 120.0}, nil, "up", slave},
+		{[]driver.Value{true, "streaming", false, 0.5}, nil, "up", slave},
+		// reads go to master while replica lag behind
+		{[]driver.Value{true, "streaming", false, 3.5}, nil, "lagging", master},
+		{[]driver.Value{false, nil, false, nil}, nil, "up", slave},
+		// replica that stopped streaming may be far behind, even when it replayed what it received
+		{[]driver.Value{true, "waiting", true, 0.0}, nil, "lagging", master},
+		{[]driver.Value{true, "streaming", true, 0.0}, nil, "up", slave},
+		// lag that can't be measured isn't trusted
+		{nil, errors.New("timeout"), "lagging", master},
+		{[]driver.Value{true, "streaming", false, nil}, nil, "lagging", master},
+		{[]driver.Value{true, "streaming", false, 0.2}, nil, "up", slave},
+		// status isn't visible to role without pg_read_all_stats, lag alone decide
+		{[]driver.Value{true, nil, true, 120.0}, nil, "up", slave},
+		{[]driver.Value{true, nil, false, 0.5}, nil, "up", slave},
+		{[]driver.Value{true, nil, false, 3.5}, nil, "lagging", master},
+	}
+
+	for index, tcase := range testCase {
+		if tcase.QueryError != nil {
+			mock.ExpectQuery(`pg_stat_wal_receiver`).WillReturnError(tcase.QueryError)
+		} else {
+			mock.ExpectQuery(`pg_stat_wal_receiver`).WillReturnRows(sqlmock.NewRows(lagColumns).AddRow(tcase.Row...))
+		}
+		slaves.check(slaves.replicas[0])
+
+		if state := Health()["main:slave"]; state != tcase.ExpectedState {
+			t.Errorf("[TestCheck] tcase:%v state got %v | expected %v", index, state, tcase.ExpectedState)
+		}
+
+		if got, _ := Conn("main", "slave"); got != tcase.ExpectedConn {
+			t.Errorf("[TestCheck] tcase:%v conn got %p | expected %p", index, got, tcase.ExpectedConn)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestCheck] expectation got %v | expected nil", err)
+	}
+}
@@ -0,0 +1,281 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type (
+	// stmtRegistry keep registered queries and their statements prepared on each pool
+	// pool is either master or one replica, so failover to other pool prepare it there
+	stmtRegistry struct {
+		sync.Mutex
+		names    []string
+		queries  map[string]string
+		prepared map[*sqlx.DB]map[string]*preparedStmt
+	}
+
+	// preparedStmt is statement shared by every request, dropped statement is
+	// closed once no request that got it before is still about to use it
+	preparedStmt struct {
+		stmt    *sqlx.Stmt
+		refs    int
+		dropped bool
+	}
+
+	// Stmt is prepared statement of registered query, statement that the server
+	// no longer knows is dropped when it fails, and prepared again on next use
+	// Stmt is used for one query, it's released by the query
+	Stmt struct {
+		*sqlx.Stmt
+		db       *sqlx.DB
+		name     string
+		entry    *preparedStmt
+		released int32
+	}
+)
+
+var statements = &stmtRegistry{
+	queries:  make(map[string]string),
+	prepared: make(map[*sqlx.DB]map[string]*preparedStmt),
+}
+
+// RegisterQuery add named query to be prepared on first use,
+// registering the same name with other query replace it
+func RegisterQuery(name, query string) {
+	statements.Lock()
+	defer statements.Unlock()
+
+	old, ok := statements.queries[name]
+	if !ok {
+		statements.names = append(statements.names, name)
+	}
+	if old == query {
+		return
+	}
+	statements.queries[name] = query
+
+	// statements of old query must not be used anymore
+	for _, prepared := range statements.prepared {
+		if p, ok := prepared[name]; ok {
+			p.drop()
+			delete(prepared, name)
+		}
+	}
+}
+
+// ReadStmt return prepared statement for read, on replica chosen by ReadConn
+// read inside transaction on dbname is bound to the transaction, so it see its writes
+func ReadStmt(ctx context.Context, dbname, name string) (*Stmt, error) {
+	if InTx(ctx, dbname) {
+		return TxStmt(ctx, name)
+	}
+
+	dbconn, err := ReadConn(ctx, dbname)
+	if err != nil {
+		return nil, err
+	}
+
+	return statements.get(ctx, dbconn, dbname, name)
+}
+
+// TxStmt return prepared statement bound to transaction of ctx, started by WithTx
+func TxStmt(ctx context.Context, name string) (*Stmt, error) {
+	uow, ok := ctx.Value(txKey{}).(*unitOfWork)
+	if !ok {
+		return nil, errors.New("no transaction in context")
+	}
+
+	dbconn, err := Conn(uow.dbname, "master")
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := statements.get(ctx, dbconn, uow.dbname, name)
+	if err != nil {
+		return nil, err
+	}
+	defer s.release()
+
+	// transaction statement is closed with the transaction, it keeps the shared
+	// statement open while it's used, and prepare it again if it's closed already
+	return &Stmt{Stmt: uow.tx.StmtxContext(ctx, s.Stmt), db: dbconn, name: name}, nil
+}
+
+// WarmStmt prepare every registered query on master and replicas of dbname,
+// failure is only reported, the statement is prepared again on first use
+func WarmStmt(dbname string) error {
+	dbconn, ok := databases[dbname]
+	if !ok {
+		return fmt.Errorf("database %s not found", dbname)
+	}
+
+	pools := []*sqlx.DB{dbconn.Master}
+	for _, r := range dbconn.Slaves.replicas {
+		pools = append(pools, r.db)
+	}
+
+	statements.Lock()
+	names := append([]string{}, statements.names...)
+	statements.Unlock()
+
+	var firstErr error
+	for _, db := range pools {
+		for _, name := range names {
+			s, err := statements.get(context.Background(), db, dbname, name)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			s.release()
+		}
+	}
+
+	return firstErr
+}
+
+// QueryRowxContext run query that return one row, see sqlx.Stmt
+// row keeps the statement open until it's scanned
+func (s *Stmt) QueryRowxContext(ctx context.Context, args ...interface{}) *sqlx.Row {
+	defer s.release()
+
+	row := s.Stmt.QueryRowxContext(ctx, args...)
+	statements.failed(s, row.Err())
+	return row
+}
+
+// QueryxContext run query that return rows, see sqlx.Stmt
+// rows keep the statement open until they're closed
+func (s *Stmt) QueryxContext(ctx context.Context, args ...interface{}) (*sqlx.Rows, error) {
+	defer s.release()
+
+	rows, err := s.Stmt.QueryxContext(ctx, args...)
+	statements.failed(s, err)
+	return rows, err
+}
+
+// ExecContext run query that doesn't return rows, see sqlx.Stmt
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	defer s.release()
+
+	res, err := s.Stmt.ExecContext(ctx, args...)
+	statements.failed(s, err)
+	return res, err
+}
+
+// release let dropped statement be closed, transaction statement has nothing to release
+func (s *Stmt) release() {
+	if s.entry == nil || !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return
+	}
+
+	statements.Lock()
+	defer statements.Unlock()
+
+	s.entry.refs--
+	if s.entry.dropped && s.entry.refs == 0 {
+		s.entry.stmt.Close()
+	}
+}
+
+// drop remove statement from use, it's closed right away unless it's still held
+// registry lock must be held
+func (p *preparedStmt) drop() {
+	p.dropped = true
+	if p.refs == 0 {
+		p.stmt.Close()
+	}
+}
+
+// get return statement of name prepared on db, prepare it first if needed
+// preparing doesn't hold the lock, so slow pool doesn't block the others
+func (r *stmtRegistry) get(ctx context.Context, db *sqlx.DB, dbname, name string) (*Stmt, error) {
+	r.Lock()
+	query, ok := r.queries[name]
+	if p := r.prepared[db][name]; ok && p != nil {
+		p.refs++
+		r.Unlock()
+		return &Stmt{Stmt: p.stmt, db: db, name: name, entry: p}, nil
+	}
+	r.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("query %s isn't registered", name)
+	}
+
+	s, err := db.PreparexContext(ctx, query)
+	if err != nil {
+		metrics.StatementPrepareFailure(dbname, name)
+		logger.Error(ctx, "fail prepare query", "func", "database.Stmt", "database", dbname, "statement", name, "error", err)
+		return nil, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// other request may have prepared it meanwhile
+	if existing := r.prepared[db][name]; existing != nil {
+		s.Close()
+		existing.refs++
+		return &Stmt{Stmt: existing.stmt, db: db, name: name, entry: existing}, nil
+	}
+
+	if r.prepared[db] == nil {
+		r.prepared[db] = make(map[string]*preparedStmt)
+	}
+	p := &preparedStmt{stmt: s, refs: 1}
+	r.prepared[db][name] = p
+
+	return &Stmt{Stmt: s, db: db, name: name, entry: p}, nil
+}
+
+// failed drop statement when err show the server doesn't know it anymore,
+// like after failover to other server or schema change
+func (r *stmtRegistry) failed(s *Stmt, err error) {
+	if !stmtInvalid(err) {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	// statement that was prepared again meanwhile is kept
+	if p, ok := r.prepared[s.db][s.name]; ok && (s.entry == nil || p == s.entry) {
+		p.drop()
+		delete(r.prepared[s.db], s.name)
+	}
+}
+
+// invalidate drop every statement prepared on db, it's used when the pool is down
+func (r *stmtRegistry) invalidate(db *sqlx.DB) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, p := range r.prepared[db] {
+		p.drop()
+	}
+	delete(r.prepared, db)
+}
+
+func stmtInvalid(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	// invalid_sql_statement_name is unknown statement,
+	// feature_not_supported is "cached plan must not change result type"
+	return pqErr.Code == "26000" || pqErr.Code == "0A000"
+}
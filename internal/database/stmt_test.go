@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestReadStmt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbconn := sqlx.NewDb(db, "postgres")
+	databases = map[string]databaseReplication{"main": {Master: dbconn, Slaves: newReplicaSet("main", "", []*sqlx.DB{dbconn})}}
+
+	RegisterQuery("test.get", "SELECT name FROM test WHERE id = $1")
+
+	testCase := []struct {
+		ExpectPrepare bool
+		QueryErr      error
+	}{
+		// prepared on first use only
+		{true, nil},
+		{false, nil},
+		// other error doesn't drop the statement
+		{false, errors.New("timeout")},
+		{false, nil},
+		// statement the server doesn't know is prepared again
+		{false, &pq.Error{Code: "26000"}},
+		{true, nil},
+	}
+
+	var prepared *sqlmock.ExpectedPrepare
+	for index, tcase := range testCase {
+		if tcase.ExpectPrepare {
+			prepared = mock.ExpectPrepare("SELECT name FROM test")
+		}
+
+		query := prepared.ExpectQuery().WithArgs(1)
+		if tcase.QueryErr != nil {
+			query.WillReturnError(tcase.QueryErr)
+		} else {
+			query.WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a"))
+		}
+
+		stmt, err := ReadStmt(context.Background(), "main", "test.get")
+		if err != nil {
+			t.Fatalf("[TestReadStmt] tcase:%v err got %v | expected nil", index, err)
+		}
+
+		var name string
+		err = stmt.QueryRowxContext(context.Background(), 1).Scan(&name)
+		if (err != nil) != (tcase.QueryErr != nil) {
+			t.Errorf("[TestReadStmt] tcase:%v query err got %v | expected %v", index, err, tcase.QueryErr)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestReadStmt] expectation got %v | expected nil", err)
+	}
+
+	if _, err := ReadStmt(context.Background(), "main", "test.unknown"); err == nil {
+		t.Errorf("[TestReadStmt] unregistered got nil | expected error")
+	}
+}
+
+func TestStmtDroppedWhileHeld(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbconn := sqlx.NewDb(db, "postgres")
+	databases = map[string]databaseReplication{"main": {Master: dbconn, Slaves: newReplicaSet("main", "", []*sqlx.DB{dbconn})}}
+
+	RegisterQuery("test.held", "SELECT name FROM test WHERE id = $1")
+
+	mock.ExpectPrepare("SELECT name FROM test WHERE id").ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("a"))
+
+	stmt, err := ReadStmt(context.Background(), "main", "test.held")
+	if err != nil {
+		t.Fatalf("[TestStmtDroppedWhileHeld] err got %v | expected nil", err)
+	}
+
+	// statement is replaced and the pool goes down while request still hold it
+	RegisterQuery("test.held", "SELECT name FROM test WHERE key = $1")
+	statements.invalidate(dbconn)
+
+	var name string
+	if err := stmt.QueryRowxContext(context.Background(), 1).Scan(&name); err != nil || name != "a" {
+		t.Errorf("[TestStmtDroppedWhileHeld] held query got %v %v | expected a", name, err)
+	}
+
+	// it's closed once it's released
+	if err := stmt.Stmt.QueryRowx(1).Err(); err == nil {
+		t.Errorf("[TestStmtDroppedWhileHeld] released query got nil | expected closed statement error")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestStmtDroppedWhileHeld] expectation got %v | expected nil", err)
+	}
+}
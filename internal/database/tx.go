@@ -0,0 +1,246 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+	"github.com/ffjabbari/go-microservice-sample/internal/metrics"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// txAttempts is the most times one transaction is run
+	txAttempts = 3
+
+	minTxBackoff = 20 * time.Millisecond
+	maxTxBackoff = time.Second
+
+	// every transaction earn one token and a retry cost retryCost tokens, up to budgetMax,
+	// so retries can't multiply load while database is struggling
+	retryCost = 10
+	budgetMax = 10 * retryCost
+)
+
+type (
+	// retryBudget is token bucket shared by every transaction
+	retryBudget struct {
+		sync.Mutex
+		tokens int
+	}
+
+	// unitOfWork is transaction shared by operations through context
+	unitOfWork struct {
+		dbname      string
+		tx          *sqlx.Tx
+		afterCommit []func()
+	}
+
+	txKey struct{}
+
+	// commitError is failed commit, its outcome is unknown unless database aborted it
+	commitError struct {
+		err error
+	}
+)
+
+var budget = &retryBudget{tokens: budgetMax}
+
+func (e *commitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
+
+// WithTx run fn in transaction on master of dbname and commit it when fn return nil
+// WithTx called with ctx given to fn join the same transaction, so several operations
+// commit or roll back together, only the outermost call commit and retry
+// transaction that fail with serialization failure, deadlock or lost connection
+// is run again with jittered backoff, so side effects outside tx belong to AfterCommit
+func WithTx(ctx context.Context, dbname string, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	if uow, ok := ctx.Value(txKey{}).(*unitOfWork); ok {
+		if uow.dbname != dbname {
+			return fmt.Errorf("transaction on database %s can't join transaction on %s", dbname, uow.dbname)
+		}
+		return fn(ctx, uow.tx)
+	}
+
+	dbconn, err := Conn(dbname, "master")
+	if err != nil {
+		return err
+	}
+
+	budget.earn()
+
+	for attempt := 0; ; attempt++ {
+		err = runTx(ctx, dbconn, dbname, fn)
+		// caller that is gone or out of time doesn't wait for another run
+		if err == nil || ctx.Err() != nil || !retryable(err) || attempt+1 >= txAttempts {
+			return err
+		}
+
+		if !budget.spend() {
+			metrics.DatabaseRetry(dbname, "budget_exhausted")
+			return err
+		}
+		metrics.DatabaseRetry(dbname, "retried")
+
+		wait := txBackoff(attempt)
+		logger.Warn(ctx, "transaction failed, retrying", "func", "database.WithTx", "database", dbname, "attempt", attempt+1, "retry_in", wait.String(), "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// InTx report whether ctx holds transaction on dbname, started by WithTx
+func InTx(ctx context.Context, dbname string) bool {
+	uow, ok := ctx.Value(txKey{}).(*unitOfWork)
+	return ok && uow.dbname == dbname
+}
+
+// Queryer return transaction of ctx when it's on dbname, so reads see writes made in it,
+// otherwise master, or replica chosen by ReadConn for other replication
+func Queryer(ctx context.Context, dbname, replication string) (sqlx.QueryerContext, error) {
+	if uow, ok := ctx.Value(txKey{}).(*unitOfWork); ok && uow.dbname == dbname {
+		return uow.tx, nil
+	}
+
+	var dbconn *sqlx.DB
+	var err error
+	if replication == "master" {
+		dbconn, err = Conn(dbname, "master")
+	} else {
+		dbconn, err = ReadConn(ctx, dbname)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return dbconn, nil
+}
+
+// AfterCommit run f once transaction of ctx is committed, it's dropped when it's rolled back
+// outside transaction f run right away
+func AfterCommit(ctx context.Context, f func()) {
+	if uow, ok := ctx.Value(txKey{}).(*unitOfWork); ok {
+		uow.afterCommit = append(uow.afterCommit, f)
+		return
+	}
+
+	f()
+}
+
+func runTx(ctx context.Context, dbconn *sqlx.DB, dbname string, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	tx, err := dbconn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	uow := &unitOfWork{dbname: dbname, tx: tx}
+	err = fn(context.WithValue(ctx, txKey{}, uow), tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return &commitError{err: err}
+	}
+
+	for _, f := range uow.afterCommit {
+		f()
+	}
+
+	return nil
+}
+
+// retryable report whether transaction can safely be run again
+// commit that lost connection may have been applied, so only aborted commit is retried
+// context error is never retried, context.DeadlineExceeded is a net.Error too
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		// serialization_failure, deadlock_detected
+		case "40001", "40P01":
+			return true
+		// prepared statement the server doesn't know, it's prepared again by the next run
+		case "26000", "0A000":
+			return true
+		// admin_shutdown, crash_shutdown, cannot_connect_now
+		case "57P01", "57P02", "57P03":
+			return !isCommitError(err)
+		}
+		// connection_exception
+		return pqErr.Code.Class() == "08" && !isCommitError(err)
+	}
+
+	if isCommitError(err) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.As(err, &netErr)
+}
+
+func isCommitError(err error) bool {
+	var commitErr *commitError
+	return errors.As(err, &commitErr)
+}
+
+// txBackoff return exponential wait before retry with full jitter
+func txBackoff(attempt int) time.Duration {
+	wait := maxTxBackoff
+	if attempt < 6 {
+		wait = minTxBackoff << uint(attempt)
+	}
+
+	return time.Duration(rand.Int63n(int64(wait))) + minTxBackoff/2
+}
+
+func (b *retryBudget) earn() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.tokens++
+	if b.tokens > budgetMax {
+		b.tokens = budgetMax
+	}
+}
+
+func (b *retryBudget) spend() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.tokens < retryCost {
+		return false
+	}
+
+	b.tokens -= retryCost
+	return true
+}
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestRetryable(t *testing.T) {
+	testCase := []struct {
+		Err      error
+		Expected bool
+	}{
+		{errors.New("syntax error"), false},
+		{&pq.Error{Code: "23505"}, false},
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{driver.ErrBadConn, true},
+		{io.EOF, true},
+		// commit aborted by database can be run again
+		{&commitError{err: &pq.Error{Code: "40001"}}, true},
+		// commit that lost connection may have been applied
+		{&commitError{err: &pq.Error{Code: "08006"}}, false},
+		{&commitError{err: io.EOF}, false},
+		// caller is gone or out of time
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{fmt.Errorf("read: %w", context.DeadlineExceeded), false},
+	}
+
+	for index, tcase := range testCase {
+		if got := retryable(tcase.Err); got != tcase.Expected {
+			t.Errorf("[TestRetryable] tcase:%v got %v | expected %v", index, got, tcase.Expected)
+		}
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	testCase := []struct {
+		Errs          []error
+		ExpectedRuns  int
+		ExpectedError bool
+	}{
+		{[]error{nil}, 1, false},
+		{[]error{errors.New("syntax error")}, 1, true},
+		{[]error{&pq.Error{Code: "40001"}, nil}, 2, false},
+		{[]error{&pq.Error{Code: "40P01"}, driver.ErrBadConn, nil}, 3, false},
+		// attempts are bounded
+		{[]error{&pq.Error{Code: "40001"}, &pq.Error{Code: "40001"}, &pq.Error{Code: "40001"}}, 3, true},
+	}
+
+	for index, tcase := range testCase {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbconn := sqlx.NewDb(db, "postgres")
+		databases = map[string]databaseReplication{"main": {Master: dbconn, Slaves: newReplicaSet("main", "", []*sqlx.DB{dbconn})}}
+		budget = &retryBudget{tokens: budgetMax}
+
+		for _, runErr := range tcase.Errs {
+			mock.ExpectBegin()
+			if runErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+		}
+
+		runs := 0
+		err = WithTx(context.Background(), "main", func(ctx context.Context, tx *sqlx.Tx) error {
+			runs++
+			return tcase.Errs[runs-1]
+		})
+
+		if (err != nil) != tcase.ExpectedError {
+			t.Errorf("[TestWithTx] tcase:%v err got %v | expected %v", index, err, tcase.ExpectedError)
+		}
+
+		if runs != tcase.ExpectedRuns {
+			t.Errorf("[TestWithTx] tcase:%v runs got %v | expected %v", index, runs, tcase.ExpectedRuns)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("[TestWithTx] tcase:%v expectation got %v | expected nil", index, err)
+		}
+	}
+}
+
+func TestWithTxContextDone(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbconn := sqlx.NewDb(db, "postgres")
+	databases = map[string]databaseReplication{"main": {Master: dbconn, Slaves: newReplicaSet("main", "", []*sqlx.DB{dbconn})}}
+	budget = &retryBudget{tokens: budgetMax}
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	// connection error of caller that is gone isn't retried
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	err = WithTx(ctx, "main", func(ctx context.Context, tx *sqlx.Tx) error {
+		runs++
+		cancel()
+		return driver.ErrBadConn
+	})
+
+	if err == nil || runs != 1 {
+		t.Errorf("[TestWithTxContextDone] got %v runs %v | expected error and 1 run", err, runs)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := &retryBudget{tokens: 2 * retryCost}
+
+	testCase := []bool{true, true, false, false}
+	for index, expected := range testCase {
+		if got := b.spend(); got != expected {
+			t.Errorf("[TestRetryBudget] tcase:%v got %v | expected %v", index, got, expected)
+		}
+	}
+
+	// ten transactions earn one retry
+	for i := 0; i < 10; i++ {
+		b.earn()
+	}
+	if !b.spend() {
+		t.Errorf("[TestRetryBudget] earned got false | expected true")
+	}
+}
+
+func TestUnitOfWork(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbconn := sqlx.NewDb(db, "postgres")
+	databases = map[string]databaseReplication{"main": {Master: dbconn, Slaves: newReplicaSet("main", "", []*sqlx.DB{dbconn})}}
+
+	testCase := []struct {
+		InnerErr      error
+		ExpectedHooks []string
+		ExpectedError bool
+	}{
+		// nested operations commit once, then their side effects run in order
+		{nil, []string{"inner", "outer"}, false},
+		// rolled back transaction drop side effects
+		{errors.New("invalid"), nil, true},
+	}
+
+	for index, tcase := range testCase {
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO a").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO b").WillReturnResult(sqlmock.NewResult(1, 1))
+		if tcase.InnerErr != nil {
+			mock.ExpectRollback()
+		} else {
+			mock.ExpectCommit()
+		}
+
+		var hooks []string
+		err := WithTx(context.Background(), "main", func(ctx context.Context, tx *sqlx.Tx) error {
+			tx.ExecContext(ctx, "INSERT INTO a")
+
+			err := WithTx(ctx, "main", func(ctx context.Context, inner *sqlx.Tx) error {
+				if inner != tx {
+					t.Errorf("[TestUnitOfWork] tcase:%v inner tx got %p | expected %p", index, inner, tx)
+				}
+				inner.ExecContext(ctx, "INSERT INTO b")
+				AfterCommit(ctx, func() { hooks = append(hooks, "inner") })
+				return tcase.InnerErr
+			})
+			if err != nil {
+				return err
+			}
+
+			if len(hooks) != 0 {
+				t.Errorf("[TestUnitOfWork] tcase:%v hooks before commit got %v | expected none", index, hooks)
+			}
+			AfterCommit(ctx, func() { hooks = append(hooks, "outer") })
+
+			// other database can't join
+			if err := WithTx(ctx, "other", func(ctx context.Context, tx *sqlx.Tx) error { return nil }); err == nil {
+				t.Errorf("[TestUnitOfWork] tcase:%v other database got nil | expected error", index)
+			}
+
+			return nil
+		})
+
+		if (err != nil) != tcase.ExpectedError {
+			t.Errorf("[TestUnitOfWork] tcase:%v err got %v | expected %v", index, err, tcase.ExpectedError)
+		}
+
+		if !reflect.DeepEqual(hooks, tcase.ExpectedHooks) {
+			t.Errorf("[TestUnitOfWork] tcase:%v hooks got %v | expected %v", index, hooks, tcase.ExpectedHooks)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestUnitOfWork] expectation got %v | expected nil", err)
+	}
+
+	// outside transaction side effect run right away
+	ran := false
+	AfterCommit(context.Background(), func() { ran = true })
+	if !ran {
+		t.Errorf("[TestUnitOfWork] no transaction got not run | expected run")
+	}
+}
+
+func TestReadInTx(t *testing.T) {
+	masterDB, masterMock, _ := sqlmock.New()
+	slaveDB, _, _ := sqlmock.New()
+	master := sqlx.NewDb(masterDB, "postgres")
+	slave := sqlx.NewDb(slaveDB, "postgres")
+	databases = map[string]databaseReplication{
+		"main": {Master: master, Slaves: newReplicaSet("main", "", []*sqlx.DB{slave})},
+	}
+
+	RegisterQuery("test.tx_get", "SELECT name FROM test WHERE id = $1")
+
+	// read outside transaction go to replica
+	if q, _ := Queryer(context.Background(), "main", "slave"); q != slave {
+		t.Errorf("[TestReadInTx] outside tx got %v | expected slave", q)
+	}
+
+	// statement is prepared on the pool, then again on connection of the transaction
+	masterMock.ExpectBegin()
+	masterMock.ExpectPrepare("SELECT name FROM test")
+	masterMock.ExpectPrepare("SELECT name FROM test").ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("uncommitted"))
+	masterMock.ExpectCommit()
+
+	err := WithTx(context.Background(), "main", func(ctx context.Context, tx *sqlx.Tx) error {
+		if !InTx(ctx, "main") || InTx(ctx, "other") {
+			t.Errorf("[TestReadInTx] InTx got main %v other %v | expected true false", InTx(ctx, "main"), InTx(ctx, "other"))
+		}
+
+		// read inside transaction see its writes
+		if q, _ := Queryer(ctx, "main", "slave"); q != tx {
+			t.Errorf("[TestReadInTx] inside tx got %v | expected tx", q)
+		}
+
+		stmt, err := ReadStmt(ctx, "main", "test.tx_get")
+		if err != nil {
+			return err
+		}
+
+		var name string
+		return stmt.QueryRowxContext(ctx, 1).Scan(&name)
+	})
+	if err != nil {
+		t.Errorf("[TestReadInTx] err got %v | expected nil", err)
+	}
+
+	if err := masterMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("[TestReadInTx] expectation got %v | expected nil", err)
+	}
+}
@@ -0,0 +1,176 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/cache"
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+
+	"gopkg.in/redis.v5"
+)
+
+type (
+	// Record is what stored for one idempotency key
+	// it's in progress until the first request finish, then it holds the response
+	Record struct {
+		Fingerprint string      `json:"fingerprint"`
+		Completed   bool        `json:"completed"`
+		Status      int         `json:"status,omitempty"`
+		Header      http.Header `json:"header,omitempty"`
+		Body        []byte      `json:"body,omitempty"`
+	}
+)
+
+var (
+	// ErrMismatch is returned when key is reused with different request
+	ErrMismatch = errors.New("idempotency key reused with different request")
+
+	// ErrInProgress is returned when the first request with the key hasn't finished
+	ErrInProgress = errors.New("request with idempotency key in progress")
+)
+
+const (
+	defaultTTL  = 24 * time.Hour
+	defaultLock = time.Minute
+)
+
+var conf config.Idempotency
+
+// Init is for load idempotency config, redis connection of enabled idempotency must exist
+func Init(idemConf config.Idempotency) error {
+	conf = idemConf
+	if conf.Cache == "" {
+		conf.Cache = "main"
+	}
+
+	if conf.Enabled && !cache.Exists(conf.Cache) {
+		return fmt.Errorf("idempotency redis connection %s not found", conf.Cache)
+	}
+
+	return nil
+}
+
+// Enabled check if idempotency key is supported
+func Enabled() bool {
+	return conf.Enabled
+}
+
+// Fingerprint identify a request, the same key must always come with the same fingerprint
+func Fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Begin claim key for the request identified by fingerprint
+// it return nil record when the caller should process the request,
+// or the stored record when the request was already completed
+func Begin(ctx context.Context, key, fingerprint string) (*Record, error) {
+	rds, err := cache.Conn(conf.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, _ := json.Marshal(Record{Fingerprint: fingerprint})
+
+	// the key can expire between SETNX and GET, so try once more before giving up
+	for i := 0; i < 2; i++ {
+		acquired, err := rds.SetNX(cacheKey(key), pending, lockTTL()).Result()
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return nil, nil
+		}
+
+		stored, err := rds.Get(cacheKey(key)).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rec := &Record{}
+		if err := json.Unmarshal(stored, rec); err != nil {
+			return nil, err
+		}
+
+		if rec.Fingerprint != fingerprint {
+			return nil, ErrMismatch
+		}
+		if !rec.Completed {
+			return nil, ErrInProgress
+		}
+
+		return rec, nil
+	}
+
+	return nil, ErrInProgress
+}
+
+// Complete store response of the request that claimed key, it's replayed until ttl
+func Complete(ctx context.Context, key string, rec Record) error {
+	rds, err := cache.Conn(conf.Cache)
+	if err != nil {
+		return err
+	}
+
+	rec.Completed = true
+	recByte, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	err = rds.Set(cacheKey(key), recByte, ttl()).Err()
+	if err != nil {
+		logger.Error(ctx, "fail store idempotent response", "func", "idempotency.Complete", "error", err)
+	}
+
+	return err
+}
+
+// Release drop claim of key, so the request can be retried with it
+func Release(ctx context.Context, key string) {
+	rds, err := cache.Conn(conf.Cache)
+	if err != nil {
+		return
+	}
+
+	if err := rds.Del(cacheKey(key)).Err(); err != nil {
+		logger.Warn(ctx, "fail release idempotency key", "func", "idempotency.Release", "error", err)
+	}
+}
+
+func cacheKey(key string) string {
+	return "idempotency:" + key
+}
+
+func ttl() time.Duration {
+	if conf.TTLSeconds > 0 {
+		return time.Duration(conf.TTLSeconds) * time.Second
+	}
+
+	return defaultTTL
+}
+
+func lockTTL() time.Duration {
+	if conf.LockSeconds > 0 {
+		return time.Duration(conf.LockSeconds) * time.Second
+	}
+
+	return defaultLock
+}
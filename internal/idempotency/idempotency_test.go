@@ -0,0 +1,75 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/cache"
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+
+	"github.com/alicebob/miniredis"
+)
+
+func init() {
+	// Run mini redis
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+
+	// Create mock redis connection
+	cacheConf := make(map[string]config.Redis)
+	cacheConf["main"] = config.Redis{Addr: s.Addr()}
+	cache.ConnectRedis(cacheConf)
+
+	Init(config.Idempotency{Enabled: true})
+}
+
+func TestBegin(t *testing.T) {
+	ctx := context.Background()
+	first := Fingerprint("POST", "/v1/contacts", []byte(`{"name":"a"}`))
+	other := Fingerprint("POST", "/v1/contacts", []byte(`{"name":"b"}`))
+
+	testCase := []struct {
+		Key            string
+		Fingerprint    string
+		Complete       bool
+		ExpectedRecord bool
+		ExpectedErr    error
+	}{
+		// first request claim the key
+		{"key1", first, false, false, nil},
+		// retry while the first one is running
+		{"key1", first, false, false, ErrInProgress},
+		{"key1", other, false, false, ErrMismatch},
+		// first request finished
+		{"key1", first, true, true, nil},
+		{"key1", other, false, false, ErrMismatch},
+		{"key2", other, false, false, nil},
+	}
+
+	for index, tcase := range testCase {
+		if tcase.Complete {
+			Complete(ctx, tcase.Key, Record{Fingerprint: tcase.Fingerprint, Status: 201, Body: []byte("{}")})
+		}
+
+		rec, err := Begin(ctx, tcase.Key, tcase.Fingerprint)
+		if err != tcase.ExpectedErr {
+			t.Errorf("[TestBegin] tcase:%v err got %v | expected %v", index, err, tcase.ExpectedErr)
+		}
+
+		if (rec != nil) != tcase.ExpectedRecord {
+			t.Errorf("[TestBegin] tcase:%v record got %v | expected %v", index, rec, tcase.ExpectedRecord)
+		}
+
+		if rec != nil && (rec.Status != 201 || string(rec.Body) != "{}") {
+			t.Errorf("[TestBegin] tcase:%v replay got %v %s | expected 201 {}", index, rec.Status, rec.Body)
+		}
+	}
+
+	// released key can be claimed again
+	Release(ctx, "key2")
+	if rec, err := Begin(ctx, "key2", first); rec != nil || err != nil {
+		t.Errorf("[TestBegin] release got %v %v | expected claimed", rec, err)
+	}
+}
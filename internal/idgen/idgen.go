@@ -0,0 +1,197 @@
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+)
+
+// id is 64 bits: zero sign bit, 41 bits of ms since epoch, 10 bits of node and 12 bits of sequence
+// ids of one node never go backward, and ids of all nodes are ordered by time to the ms
+const (
+	nodeBits = 10
+	seqBits  = 12
+
+	maxNode = 1<<nodeBits - 1
+	maxSeq  = 1<<seqBits - 1
+
+	// epoch is 2024-01-01 UTC in unix ms, 41 bits of ms after it last until 2093
+	epoch = 1704067200000
+
+	// encodedLen is length of string form, 13 chars of 5 bits
+	encodedLen = 13
+
+	// nodeEnv hold node of the instance when it isn't in config
+	nodeEnv = "CONTACTAPP_NODE"
+)
+
+// alphabet is Crockford base32, string form sort the same way as the id
+const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type (
+	// generator hold the last ms and sequence of this node
+	generator struct {
+		sync.Mutex
+		node   int64
+		lastMs int64
+		seq    int64
+		now    func() int64
+	}
+)
+
+// ErrInvalid is returned when string isn't an id
+var ErrInvalid = errors.New("invalid id")
+
+var gen = &generator{now: func() int64 { return time.Now().UnixNano() / int64(time.Millisecond) }}
+
+// getenv and hostname are replaced in unit test
+var (
+	getenv   = os.Getenv
+	hostname = os.Hostname
+)
+
+var decoding [256]int8
+
+func init() {
+	for i := range decoding {
+		decoding[i] = -1
+	}
+	for i, c := range alphabet {
+		decoding[c] = int8(i)
+		decoding[c|0x20] = int8(i)
+	}
+
+	// Crockford base32 read letters that look like digits as the digits
+	for _, c := range "oO" {
+		decoding[c] = 0
+	}
+	for _, c := range "iIlL" {
+		decoding[c] = 1
+	}
+}
+
+// Configure set node of this instance, it must be unique among running instances
+// so it's never defaulted, instances that share one node generate the same ids
+func Configure(conf config.IDGen) error {
+	node, err := resolveNode(conf)
+	if err != nil {
+		return err
+	}
+
+	if node < 0 || node > maxNode {
+		return fmt.Errorf("node %v must be between 0 and %v", node, maxNode)
+	}
+
+	gen.Lock()
+	defer gen.Unlock()
+	gen.node = node
+
+	return nil
+}
+
+// resolveNode return node from config, CONTACTAPP_NODE env, or ordinal that
+// ends hostname of a stateful set pod, in that order
+func resolveNode(conf config.IDGen) (int64, error) {
+	if conf.Node != nil {
+		return *conf.Node, nil
+	}
+
+	if env := getenv(nodeEnv); env != "" {
+		node, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", nodeEnv, env)
+		}
+		return node, nil
+	}
+
+	host, err := hostname()
+	if err == nil {
+		if i := strings.LastIndex(host, "-"); i >= 0 {
+			if node, err := strconv.ParseInt(host[i+1:], 10, 64); err == nil {
+				return node, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("node isn't set, set id_generator.node, %s or run on hostname ending with ordinal", nodeEnv)
+}
+
+// Next return new id
+func Next() int64 {
+	return gen.next()
+}
+
+func (g *generator) next() int64 {
+	g.Lock()
+	defer g.Unlock()
+
+	// clock that went back keep using the last ms, so ids never go backward
+	ms := g.now() - epoch
+	if ms < g.lastMs {
+		ms = g.lastMs
+	}
+
+	if ms == g.lastMs {
+		g.seq++
+
+		// sequence of this ms ran out, borrow the next ms
+		if g.seq > maxSeq {
+			ms++
+			g.seq = 0
+		}
+	} else {
+		g.seq = 0
+	}
+	g.lastMs = ms
+
+	return ms<<(nodeBits+seqBits) | g.node<<seqBits | g.seq
+}
+
+// Format return string form of id, that's what clients see
+func Format(id int64) string {
+	var b [encodedLen]byte
+	for i := encodedLen - 1; i >= 0; i-- {
+		b[i] = alphabet[id&31]
+		id >>= 5
+	}
+
+	return string(b[:])
+}
+
+// Parse return id of its string form, decimal id of contacts created
+// before ids were generated is accepted too, it's never 13 digits long
+func Parse(s string) (int64, error) {
+	if len(s) != encodedLen {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			return 0, ErrInvalid
+		}
+		return id, nil
+	}
+
+	// 13 chars hold 65 bits, the first one only hold 3 bits of positive id
+	if decoding[s[0]] < 0 || decoding[s[0]] > 7 {
+		return 0, ErrInvalid
+	}
+
+	var id int64
+	for i := 0; i < len(s); i++ {
+		v := decoding[s[i]]
+		if v < 0 {
+			return 0, ErrInvalid
+		}
+		id = id<<5 | int64(v)
+	}
+
+	if id == 0 {
+		return 0, ErrInvalid
+	}
+
+	return id, nil
+}
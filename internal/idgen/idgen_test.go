@@ -0,0 +1,140 @@
+package idgen
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+)
+
+func TestNext(t *testing.T) {
+	clock := []int64{epoch + 10, epoch + 10, epoch + 11, epoch + 5}
+	g := &generator{node: 3}
+	g.now = func() int64 {
+		ms := clock[0]
+		if len(clock) > 1 {
+			clock = clock[1:]
+		}
+		return ms
+	}
+
+	testCase := []struct {
+		ExpectedMs  int64
+		ExpectedSeq int64
+	}{
+		{10, 0},
+		{10, 1},
+		{11, 0},
+		// clock went back
+		{11, 1},
+	}
+
+	for index, tcase := range testCase {
+		id := g.next()
+		ms, node, seq := id>>(nodeBits+seqBits), id>>seqBits&maxNode, id&maxSeq
+		if ms != tcase.ExpectedMs || node != 3 || seq != tcase.ExpectedSeq {
+			t.Errorf("[TestNext] tcase:%v got ms:%v node:%v seq:%v | expected ms:%v node:3 seq:%v", index, ms, node, seq, tcase.ExpectedMs, tcase.ExpectedSeq)
+		}
+	}
+
+	// sequence that ran out borrow the next ms
+	g.seq = maxSeq
+	if id := g.next(); id>>(nodeBits+seqBits) != 12 || id&maxSeq != 0 {
+		t.Errorf("[TestNext] overflow got ms:%v seq:%v | expected ms:12 seq:0", id>>(nodeBits+seqBits), id&maxSeq)
+	}
+}
+
+func TestParse(t *testing.T) {
+	testCase := []struct {
+		Input    string
+		Expected int64
+		Valid    bool
+	}{
+		{"0000000000007", 7, true},
+		{"000000000000z", 31, true},
+		{"00000000000O1", 1, true},
+		{"7ZZZZZZZZZZZZ", 1<<63 - 1, true},
+		// legacy numeric id
+		{"42", 42, true},
+		{"8000000000000", 0, false},
+		{"0000000000000", 0, false},
+		{"000000000000U", 0, false},
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"abc", 0, false},
+		{"", 0, false},
+	}
+
+	for index, tcase := range testCase {
+		got, err := Parse(tcase.Input)
+		if (err == nil) != tcase.Valid || got != tcase.Expected {
+			t.Errorf("[TestParse] tcase:%v got %v, %v | expected %v, valid %v", index, got, err, tcase.Expected, tcase.Valid)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	prev := ""
+	for i := 0; i < 1000; i++ {
+		id := Next()
+		s := Format(id)
+
+		if got, err := Parse(s); err != nil || got != id {
+			t.Errorf("[TestFormat] id:%v string:%v got %v, %v | expected %v", id, s, got, err, id)
+		}
+
+		// string form sort the same way as the id
+		if s <= prev {
+			t.Errorf("[TestFormat] id:%v string:%v | expected after %v", id, s, prev)
+		}
+		prev = s
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	node := func(n int64) *int64 {
+		return &n
+	}
+
+	testCase := []struct {
+		Node         *int64
+		Env          string
+		Hostname     string
+		ExpectedNode int64
+		Valid        bool
+	}{
+		{node(0), "", "", 0, true},
+		{node(maxNode), "", "", maxNode, true},
+		{node(maxNode + 1), "", "", 0, false},
+		{node(-1), "", "", 0, false},
+		// config win over env and hostname
+		{node(5), "6", "contactapp-7", 5, true},
+		{nil, "6", "contactapp-7", 6, true},
+		{nil, "six", "contactapp-7", 0, false},
+		{nil, "", "contactapp-7", 7, true},
+		{nil, "", "contactapp-7f9c8d", 0, false},
+		// every instance would get the same node
+		{nil, "", "", 0, false},
+	}
+
+	defer func() {
+		getenv = os.Getenv
+		hostname = os.Hostname
+	}()
+
+	for index, tcase := range testCase {
+		getenv = func(string) string { return tcase.Env }
+		hostname = func() (string, error) { return tcase.Hostname, nil }
+		gen.node = -1
+
+		err := Configure(config.IDGen{Node: tcase.Node})
+		if (err == nil) != tcase.Valid {
+			t.Errorf("[TestConfigure] tcase:%v error %v | expected valid %v", index, err, tcase.Valid)
+		}
+
+		if err == nil && gen.node != tcase.ExpectedNode {
+			t.Errorf("[TestConfigure] tcase:%v node got %v | expected %v", index, gen.node, tcase.ExpectedNode)
+		}
+	}
+	Configure(config.IDGen{Node: node(0)})
+}
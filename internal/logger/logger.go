@@ -0,0 +1,138 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+// default logger until Init is called, so nothing is lost during boot
+var base = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+// Init is for setup the global logger from config
+// sinks can be "stdout", "stderr" or file path, all sinks get the same log lines
+func Init(conf config.Log) error {
+	var level slog.Level
+	switch strings.ToLower(conf.Level) {
+	case "debug":
+		level = slog.LevelDebug
+	case "", "info":
+		level = slog.LevelInfo
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return fmt.Errorf("unknown log level %s", conf.Level)
+	}
+
+	sinks := conf.Sinks
+	if len(sinks) == 0 {
+		sinks = []string{"stderr"}
+	}
+
+	writers := []io.Writer{}
+	for _, sink := range sinks {
+		switch sink {
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		default:
+			f, err := os.OpenFile(sink, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			writers = append(writers, f)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	out := io.MultiWriter(writers...)
+
+	switch conf.Format {
+	case "", "json":
+		base = slog.New(slog.NewJSONHandler(out, opts))
+	case "text":
+		base = slog.New(slog.NewTextHandler(out, opts))
+	default:
+		return fmt.Errorf("unknown log format %s", conf.Format)
+	}
+
+	return nil
+}
+
+// NewRequestID generate random id for request that doesn't bring one
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID store request id in ctx, so every log line carries it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestID return request id stored in ctx
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// Debug write log with debug level
+func Debug(ctx context.Context, msg string, args ...interface{}) {
+	log(ctx, slog.LevelDebug, msg, args...)
+}
+
+// Info write log with info level
+func Info(ctx context.Context, msg string, args ...interface{}) {
+	log(ctx, slog.LevelInfo, msg, args...)
+}
+
+// Warn write log with warn level
+func Warn(ctx context.Context, msg string, args ...interface{}) {
+	log(ctx, slog.LevelWarn, msg, args...)
+}
+
+// Error write log with error level
+func Error(ctx context.Context, msg string, args ...interface{}) {
+	log(ctx, slog.LevelError, msg, args...)
+}
+
+// Fatal write log with error level then exit
+func Fatal(ctx context.Context, msg string, args ...interface{}) {
+	log(ctx, slog.LevelError, msg, args...)
+	os.Exit(1)
+}
+
+// log add request and trace id from ctx to args
+// args are key value pairs, like "error", err
+func log(ctx context.Context, level slog.Level, msg string, args ...interface{}) {
+	if !base.Enabled(ctx, level) {
+		return
+	}
+
+	if requestID := RequestID(ctx); requestID != "" {
+		args = append(args, "request_id", requestID)
+	}
+
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.HasTraceID() {
+		args = append(args, "trace_id", spanCtx.TraceID().String())
+	}
+
+	base.Log(ctx, level, msg, args...)
+}
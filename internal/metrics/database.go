@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// dbStatsCollector read sql.DBStats of every database connection on each scrape
+	dbStatsCollector struct {
+		source func() map[string]map[string]sql.DBStats
+	}
+)
+
+var dbLabels = []string{"database", "replication"}
+
+var (
+	dbMaxOpen = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "max_open_connections"),
+		"Maximum number of open connections to the database.", dbLabels, nil)
+
+	dbOpen = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "open_connections"),
+		"Number of established connections both in use and idle.", dbLabels, nil)
+
+	dbInUse = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "in_use_connections"),
+		"Number of connections currently in use.", dbLabels, nil)
+
+	dbIdle = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "idle_connections"),
+		"Number of idle connections.", dbLabels, nil)
+
+	dbWaitCount = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "wait_count_total"),
+		"Total number of connections waited for.", dbLabels, nil)
+
+	dbWaitDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "wait_duration_seconds_total"),
+		"Total time blocked waiting for a new connection.", dbLabels, nil)
+
+	dbMaxIdleClosed = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "max_idle_closed_total"),
+		"Total number of connections closed due to SetMaxIdleConns.", dbLabels, nil)
+
+	dbMaxLifetimeClosed = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "database", "max_lifetime_closed_total"),
+		"Total number of connections closed due to SetConnMaxLifetime.", dbLabels, nil)
+)
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbMaxOpen
+	ch <- dbOpen
+	ch <- dbInUse
+	ch <- dbIdle
+	ch <- dbWaitCount
+	ch <- dbWaitDuration
+	ch <- dbMaxIdleClosed
+	ch <- dbMaxLifetimeClosed
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for dbname, replications := range c.source() {
+		for replication, stats := range replications {
+			ch <- prometheus.MustNewConstMetric(dbMaxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections), dbname, replication)
+			ch <- prometheus.MustNewConstMetric(dbOpen, prometheus.GaugeValue, float64(stats.OpenConnections), dbname, replication)
+			ch <- prometheus.MustNewConstMetric(dbInUse, prometheus.GaugeValue, float64(stats.InUse), dbname, replication)
+			ch <- prometheus.MustNewConstMetric(dbIdle, prometheus.GaugeValue, float64(stats.Idle), dbname, replication)
+			ch <- prometheus.MustNewConstMetric(dbWaitCount, prometheus.CounterValue, float64(stats.WaitCount), dbname, replication)
+			ch <- prometheus.MustNewConstMetric(dbWaitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds(), dbname, replication)
+			ch <- prometheus.MustNewConstMetric(dbMaxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed), dbname, replication)
+			ch <- prometheus.MustNewConstMetric(dbMaxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed), dbname, replication)
+		}
+	}
+}
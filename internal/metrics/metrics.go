@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "contactapp"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of handled HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of handled HTTP requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	stmtDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "statement_duration_seconds",
+		Help:      "Latency of database statements by statement name.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"statement"})
+
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Number of cache lookups by cache name and result (hit, miss, error).",
+	}, []string{"cache", "result"})
+
+	cacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "Number of entries removed from in-process caches by cache name and reason (size, expired, invalidated).",
+	}, []string{"cache", "reason"})
+
+	cacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "entries",
+		Help:      "Number of entries held by in-process caches by cache name.",
+	}, []string{"cache"})
+
+	cacheBreaker = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "breaker_state",
+		Help:      "State of redis circuit breaker by connection name (0 closed, 1 half open, 2 open).",
+	}, []string{"cache"})
+
+	dbRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "transaction_retries_total",
+		Help:      "Transactions failed with transient error, by outcome (retried, budget_exhausted).",
+	}, []string{"database", "outcome"})
+
+	stmtPrepareFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "statement_prepare_failures_total",
+		Help:      "Prepared statements that failed to prepare, by database and statement.",
+	}, []string{"database", "statement"})
+
+	replicaLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "database",
+		Name:      "replica_lag_seconds",
+		Help:      "Replication lag of read replica, measured from last replayed transaction.",
+	}, []string{"database", "replication"})
+)
+
+// Registry is the prometheus registry used by the /metrics endpoint
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		stmtDuration,
+		cacheRequests,
+		cacheEvictions,
+		cacheEntries,
+		cacheBreaker,
+		replicaLag,
+		dbRetries,
+		stmtPrepareFailures,
+	)
+}
+
+// Handler return http handler that expose all registered metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest record one handled http request
+func ObserveRequest(route, method string, status int, duration time.Duration) {
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, code).Inc()
+	httpDuration.WithLabelValues(route, method, code).Observe(duration.Seconds())
+}
+
+// ObserveStatement record latency of a database statement since start
+func ObserveStatement(name string, start time.Time) {
+	stmtDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+// CacheHit record a cache lookup that found the key
+func CacheHit(cache string) {
+	cacheRequests.WithLabelValues(cache, "hit").Inc()
+}
+
+// CacheMiss record a cache lookup that didn't find the key
+func CacheMiss(cache string) {
+	cacheRequests.WithLabelValues(cache, "miss").Inc()
+}
+
+// CacheError record a cache lookup that failed
+func CacheError(cache string) {
+	cacheRequests.WithLabelValues(cache, "error").Inc()
+}
+
+// CacheEviction record an entry removed from in-process cache
+func CacheEviction(cache, reason string) {
+	cacheEvictions.WithLabelValues(cache, reason).Inc()
+}
+
+// CacheEntries set number of entries held by in-process cache
+func CacheEntries(cache string, n int) {
+	cacheEntries.WithLabelValues(cache).Set(float64(n))
+}
+
+// CacheBreakerState set state of redis circuit breaker
+func CacheBreakerState(cache string, state int) {
+	cacheBreaker.WithLabelValues(cache).Set(float64(state))
+}
+
+// ReplicaLag set replication lag of read replica
+func ReplicaLag(database, replication string, lag time.Duration) {
+	replicaLag.WithLabelValues(database, replication).Set(lag.Seconds())
+}
+
+// DatabaseRetry count transaction failed with transient error
+func DatabaseRetry(database, outcome string) {
+	dbRetries.WithLabelValues(database, outcome).Inc()
+}
+
+// StatementPrepareFailure count statement that failed to prepare
+func StatementPrepareFailure(database, statement string) {
+	stmtPrepareFailures.WithLabelValues(database, statement).Inc()
+}
+
+// CollectDBStats register collector for sql pool stats
+// source must return stats of every connection as dbname -> replication -> stats
+func CollectDBStats(source func() map[string]map[string]sql.DBStats) {
+	Registry.MustRegister(&dbStatsCollector{source: source})
+}
@@ -0,0 +1,263 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Migration is one versioned schema change, Down revert Up
+	Migration struct {
+		Version int64
+		Name    string
+		Up      string
+		Down    string
+	}
+
+	// State is a migration and when it was applied, AppliedAt is nil when it's pending
+	State struct {
+		Version   int64
+		Name      string
+		AppliedAt *time.Time
+	}
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the postgres advisory lock held while migrating,
+// so only one runner change the schema at a time
+const lockID int64 = 7267835512002184
+
+var fileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load return embedded migrations ordered by version
+// every migration must have both up and down file
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %v", entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %v has names %v and %v", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %v_%v must have up and down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up apply every pending migration, each one in its own transaction
+func Up(ctx context.Context, db *sqlx.DB) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+
+			err = run(ctx, conn, m.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("migration %v_%v up: %v", m.Version, m.Name, err)
+			}
+
+			logger.Info(ctx, "migration applied", "version", m.Version, "name", m.Name)
+			applied = append(applied, m)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down revert the last steps applied migrations, newest first
+func Down(ctx context.Context, db *sqlx.DB, steps int) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			m := migrations[i]
+			if _, ok := done[m.Version]; !ok {
+				continue
+			}
+
+			err = run(ctx, conn, m.Down, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+			if err != nil {
+				return fmt.Errorf("migration %v_%v down: %v", m.Version, m.Name, err)
+			}
+
+			logger.Info(ctx, "migration reverted", "version", m.Version, "name", m.Name)
+			reverted = append(reverted, m)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status return every embedded migration and when it was applied
+func Status(ctx context.Context, db *sqlx.DB) ([]State, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			state := State{Version: m.Version, Name: m.Name}
+			if appliedAt, ok := done[m.Version]; ok {
+				state.AppliedAt = &appliedAt
+			}
+			states = append(states, state)
+		}
+
+		return nil
+	})
+
+	return states, err
+}
+
+// withLock run fn on one session that holds the advisory lock,
+// session level lock is released by the same session, so every statement use conn
+func withLock(ctx context.Context, db *sqlx.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// appliedVersions return applied migration versions and when they were applied
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = appliedAt
+	}
+
+	return done, rows.Err()
+}
+
+// run execute migration script and record it in one transaction
+func run(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,111 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("[TestLoad] err got %v | expected nil", err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatalf("[TestLoad] migrations got none | expected some")
+	}
+
+	for index, m := range migrations {
+		if m.Version != int64(index+1) {
+			t.Errorf("[TestLoad] tcase:%v version got %v | expected %v", index, m.Version, index+1)
+		}
+
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("[TestLoad] tcase:%v %v_%v has empty up or down", index, m.Version, m.Name)
+		}
+	}
+}
+
+func TestUp(t *testing.T) {
+	migrations, _ := Load()
+
+	testCase := []struct {
+		Applied         []int64
+		FailVersion     int64
+		ExpectedApplied int
+		ExpectedErr     bool
+	}{
+		// fresh database
+		{nil, 0, len(migrations), false},
+		// up to date
+		{allVersions(migrations), 0, 0, false},
+		// only the last one is pending
+		{allVersions(migrations)[:len(migrations)-1], 0, 1, false},
+		// first migration fail, nothing after it is applied
+		{nil, 1, 0, true},
+	}
+
+	for index, tcase := range testCase {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mock.ExpectExec(`SELECT pg_advisory_lock`).WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		rows := sqlmock.NewRows([]string{"version", "applied_at"})
+		done := make(map[int64]bool)
+		for _, v := range tcase.Applied {
+			rows.AddRow(v, time.Now())
+			done[v] = true
+		}
+		mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).WillReturnRows(rows)
+
+		for _, m := range migrations {
+			if done[m.Version] {
+				continue
+			}
+
+			mock.ExpectBegin()
+			if m.Version == tcase.FailVersion {
+				mock.ExpectExec(`.+`).WillReturnError(errors.New("syntax error"))
+				mock.ExpectRollback()
+				break
+			}
+			mock.ExpectExec(`.+`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(m.Version, m.Name).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+		}
+
+		mock.ExpectExec(`SELECT pg_advisory_unlock`).WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		applied, err := Up(context.Background(), sqlx.NewDb(db, "postgres"))
+		if (err != nil) != tcase.ExpectedErr {
+			t.Errorf("[TestUp] tcase:%v err got %v | expected %v", index, err, tcase.ExpectedErr)
+		}
+
+		if len(applied) != tcase.ExpectedApplied {
+			t.Errorf("[TestUp] tcase:%v applied got %v | expected %v", index, len(applied), tcase.ExpectedApplied)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("[TestUp] tcase:%v expectation got %v | expected nil", index, err)
+		}
+
+		db.Close()
+	}
+}
+
+func allVersions(migrations []Migration) []int64 {
+	versions := make([]int64, 0, len(migrations))
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
@@ -0,0 +1,7 @@
+-- contacts as it was before tenancy, existing table is kept
+CREATE TABLE IF NOT EXISTS contacts (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	phone TEXT NOT NULL
+);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS contacts_tenant_owner_idx;
+DROP INDEX IF EXISTS contacts_tenant_id_idx;
+
+ALTER TABLE contacts
+	DROP COLUMN IF EXISTS owner_id,
+	DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,8 @@
+-- rows created before tenancy get empty tenant and owner,
+-- they're invisible until they're assigned to a tenant
+ALTER TABLE contacts
+	ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS contacts_tenant_id_idx ON contacts (tenant_id, id);
+CREATE INDEX IF NOT EXISTS contacts_tenant_owner_idx ON contacts (tenant_id, owner_id);
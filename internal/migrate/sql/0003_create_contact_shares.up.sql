@@ -0,0 +1,10 @@
+CREATE TABLE contact_shares (
+	tenant_id TEXT NOT NULL,
+	contact_id BIGINT NOT NULL REFERENCES contacts (id) ON DELETE CASCADE,
+	grantee TEXT NOT NULL,
+	permission TEXT NOT NULL CHECK (permission IN ('read', 'write')),
+	PRIMARY KEY (tenant_id, contact_id, grantee)
+);
+
+-- list_visible look up grants by grantee
+CREATE INDEX contact_shares_grantee_idx ON contact_shares (tenant_id, grantee, contact_id);
@@ -0,0 +1,10 @@
+-- key_hash is hex sha256 of the key, the key itself is never stored
+-- roles is comma separated
+CREATE TABLE api_keys (
+	key_hash TEXT PRIMARY KEY,
+	subject TEXT NOT NULL,
+	tenant_id TEXT NOT NULL,
+	roles TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	revoked_at TIMESTAMPTZ
+);
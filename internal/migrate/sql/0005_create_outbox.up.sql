@@ -0,0 +1,12 @@
+-- events written in the same transaction as the change they describe
+-- published_at is set by the relay once the event is delivered
+CREATE TABLE outbox (
+	id BIGSERIAL PRIMARY KEY,
+	tenant_id TEXT NOT NULL,
+	topic TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	published_at TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL;
@@ -1,7 +1,9 @@
 package mockcontacts
 
 import (
+	"context"
 	"fmt"
+
 	"github.com/ffjabbari/go-microservice-sample/internal/contacts"
 )
 
@@ -30,6 +32,15 @@ var McUpdate func(contacts.ContactData) error
 // McDelete is the function that will be executed by mocked contacts.Contact object
 var McDelete func() error
 
+// McShares is the function that will be executed by mocked contacts.Contact object
+var McShares func() ([]contacts.Share, error)
+
+// McShare is the function that will be executed by mocked contacts.Contact object
+var McShare func(contacts.Share) error
+
+// McUnshare is the function that will be executed by mocked contacts.Contact object
+var McUnshare func(string) error
+
 func init() {
 	// initialize default ReturnGet function
 	ReturnGet = func(contactID int64) (contacts.Contact, error) {
@@ -80,6 +91,21 @@ func init() {
 	McDelete = func() error {
 		return nil
 	}
+
+	// init default McShares function
+	McShares = func() ([]contacts.Share, error) {
+		return []contacts.Share{}, nil
+	}
+
+	// init default McShare function
+	McShare = func(share contacts.Share) error {
+		return nil
+	}
+
+	// init default McUnshare function
+	McUnshare = func(grantee string) error {
+		return nil
+	}
 }
 
 // New will return MockPkgContacts for replacing PkgContacts object
@@ -88,28 +114,40 @@ func New() contacts.PkgContacts {
 }
 
 // Get is a mock function for PkgContacts.Get() function
-func (mpc *MockPkgContacts) Get(contactID int64) (contacts.Contact, error) {
+func (mpc *MockPkgContacts) Get(ctx context.Context, contactID int64) (contacts.Contact, error) {
 	return ReturnGet(contactID)
 }
 
 // Create is a mock function for PkgContacts.Create() function
-func (mpc *MockPkgContacts) Create(input contacts.ContactData) (contacts.Contact, error) {
+func (mpc *MockPkgContacts) Create(ctx context.Context, input contacts.ContactData) (contacts.Contact, error) {
 	return ReturnCreate(input)
 }
 
 // List is a mock function for PkgContacts.List() function
-func (mpc *MockPkgContacts) List(take, page int64) ([]contacts.ContactData, error) {
+func (mpc *MockPkgContacts) List(ctx context.Context, take, page int64) ([]contacts.ContactData, error) {
 	return ReturnList(take, page)
 }
 
-func (mc *mockContact) Update(input contacts.ContactData) error {
+func (mc *mockContact) Update(ctx context.Context, input contacts.ContactData) error {
 	return McUpdate(input)
 }
 
-func (mc *mockContact) Delete() error {
+func (mc *mockContact) Delete(ctx context.Context) error {
 	return McDelete()
 }
 
 func (mc *mockContact) Data() contacts.ContactData {
 	return mc.data
 }
+
+func (mc *mockContact) Shares(ctx context.Context) ([]contacts.Share, error) {
+	return McShares()
+}
+
+func (mc *mockContact) Share(ctx context.Context, share contacts.Share) error {
+	return McShare(share)
+}
+
+func (mc *mockContact) Unshare(ctx context.Context, grantee string) error {
+	return McUnshare(grantee)
+}
@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	// localLimiter is in-process token buckets, used when redis is down
+	// each instance enforce its own limit, so the effective limit is multiplied by instance count
+	localLimiter struct {
+		sync.Mutex
+		buckets map[string]*localBucket
+	}
+
+	localBucket struct {
+		tokens   float64
+		ts       int64
+		capacity int64
+		rate     float64
+	}
+)
+
+// maxLocalBuckets is when full buckets are pruned from memory
+const maxLocalBuckets = 10000
+
+var local = &localLimiter{buckets: make(map[string]*localBucket)}
+
+// allow is the same algorithm as tokenBucket script
+func (l *localLimiter) allow(key string, capacity int64, rate float64, nowMs, requested int64) Result {
+	l.Lock()
+	defer l.Unlock()
+
+	if len(l.buckets) >= maxLocalBuckets {
+		l.prune(nowMs)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &localBucket{tokens: float64(capacity), ts: nowMs}
+		l.buckets[key] = b
+	}
+	b.capacity = capacity
+	b.rate = rate
+
+	b.tokens = math.Min(float64(capacity), b.tokens+math.Max(0, float64(nowMs-b.ts))*rate)
+	b.ts = nowMs
+
+	res := Result{Limit: capacity}
+	if b.tokens >= float64(requested) {
+		b.tokens -= float64(requested)
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((float64(requested)-b.tokens)/rate)) * time.Millisecond
+	}
+
+	res.Remaining = int64(math.Floor(b.tokens))
+	res.Reset = time.Duration(math.Ceil((float64(capacity)-b.tokens)/rate)) * time.Millisecond
+
+	return res
+}
+
+// prune remove buckets that would be full by now, they're the same as a new bucket
+func (l *localLimiter) prune(nowMs int64) {
+	for key, b := range l.buckets {
+		if b.tokens+float64(nowMs-b.ts)*b.rate >= float64(b.capacity) {
+			delete(l.buckets, key)
+		}
+	}
+}
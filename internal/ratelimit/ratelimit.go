@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/cache"
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+	"github.com/ffjabbari/go-microservice-sample/internal/logger"
+
+	"gopkg.in/redis.v5"
+)
+
+type (
+	// Result is the outcome of one limit check
+	Result struct {
+		Allowed bool
+
+		// Limit is the bucket capacity
+		Limit int64
+
+		// Remaining is number of requests left in the bucket
+		Remaining int64
+
+		// RetryAfter is how long to wait before the request can be allowed
+		RetryAfter time.Duration
+
+		// Reset is how long until the bucket is full again
+		Reset time.Duration
+	}
+)
+
+// tokenBucket refill the bucket based on elapsed time, then take requested tokens
+// KEYS[1] bucket key
+// ARGV[1] capacity, ARGV[2] refill rate in tokens per ms, ARGV[3] now in ms, ARGV[4] requested tokens
+// return {allowed, remaining, retry after ms, reset ms}
+var tokenBucket = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+else
+	retry = math.ceil((requested - tokens) / rate)
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity / rate))
+
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+
+var conf config.RateLimit
+
+// now is replaced in unit test
+var now = time.Now
+
+// Init is for load rate limit config, redis connection of enabled rate limit must exist,
+// otherwise every instance would silently keep its own buckets
+func Init(rateConf config.RateLimit) error {
+	conf = rateConf
+	if conf.Cache == "" {
+		conf.Cache = "main"
+	}
+
+	if conf.Enabled && !cache.Exists(conf.Cache) {
+		return fmt.Errorf("rate limit redis connection %s not found", conf.Cache)
+	}
+
+	return nil
+}
+
+// Enabled check if rate limit is turned on
+func Enabled() bool {
+	return conf.Enabled
+}
+
+// Limits return limits that apply to a route, keyed by limit name
+// "client" is the limit across all routes, the others are per route
+func Limits(method, route string) map[string]config.Limit {
+	limits := make(map[string]config.Limit)
+
+	if valid(conf.Client) {
+		limits["client"] = conf.Client
+	}
+
+	if limit, ok := conf.Routes[method+" "+route]; ok && valid(limit) {
+		limits[method+" "+route] = limit
+	}
+
+	return limits
+}
+
+// AnonymousLimit return limit per ip of requests that fail authentication
+func AnonymousLimit() (config.Limit, bool) {
+	return conf.Anonymous, valid(conf.Anonymous)
+}
+
+// Allow take one request from the bucket identified by key
+// redis is used so all instances share the bucket, if redis fails
+// the in-process bucket of this instance is used instead
+func Allow(ctx context.Context, key string, limit config.Limit) Result {
+	return take(ctx, key, limit, 1)
+}
+
+// Peek check if the bucket identified by key has a request left without taking it
+func Peek(ctx context.Context, key string, limit config.Limit) Result {
+	res := take(ctx, key, limit, 0)
+
+	res.Allowed = res.Remaining >= 1
+	if !res.Allowed {
+		// at most the time one request is refilled
+		res.RetryAfter = time.Duration(limit.Seconds) * time.Second / time.Duration(limit.Requests)
+	}
+
+	return res
+}
+
+func take(ctx context.Context, key string, limit config.Limit, requested int64) Result {
+	capacity := limit.Requests
+	rate := float64(limit.Requests) / float64(limit.Seconds*1000)
+	nowMs := now().UnixNano() / int64(time.Millisecond)
+
+	rds, err := cache.Conn(conf.Cache)
+	if err == nil {
+		var res interface{}
+		res, err = tokenBucket.Run(rds, []string{"ratelimit:" + key},
+			capacity,
+			strconv.FormatFloat(rate, 'f', -1, 64),
+			nowMs,
+			requested,
+		).Result()
+		if err == nil {
+			return parseResult(res, capacity)
+		}
+	}
+
+	logger.Warn(ctx, "redis rate limit unavailable, use local bucket", "func", "ratelimit.Allow", "error", err)
+
+	return local.allow(key, capacity, rate, nowMs, requested)
+}
+
+func parseResult(res interface{}, capacity int64) Result {
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 4 {
+		return Result{Allowed: true, Limit: capacity, Remaining: capacity}
+	}
+
+	ints := make([]int64, 4)
+	for i, v := range values {
+		ints[i], _ = v.(int64)
+	}
+
+	return Result{
+		Allowed:    ints[0] == 1,
+		Limit:      capacity,
+		Remaining:  ints[1],
+		RetryAfter: time.Duration(ints[2]) * time.Millisecond,
+		Reset:      time.Duration(ints[3]) * time.Millisecond,
+	}
+}
+
+func valid(limit config.Limit) bool {
+	return limit.Requests > 0 && limit.Seconds > 0
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/cache"
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+
+	"github.com/alicebob/miniredis"
+)
+
+var clock time.Time
+
+func init() {
+	// Run mini redis
+	s, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+
+	// Create mock redis connection
+	cacheConf := make(map[string]config.Redis)
+	cacheConf["main"] = config.Redis{Addr: s.Addr()}
+	cache.ConnectRedis(cacheConf)
+
+	clock = time.Unix(1500000000, 0)
+	now = func() time.Time {
+		return clock
+	}
+
+	Init(config.RateLimit{Enabled: true})
+}
+
+func TestAllow(t *testing.T) {
+	limit := config.Limit{Requests: 2, Seconds: 10}
+
+	testCase := []struct {
+		Key               string
+		Advance           time.Duration
+		ExpectedAllowed   bool
+		ExpectedRemaining int64
+	}{
+		{"client1", 0, true, 1},
+		{"client1", 0, true, 0},
+		{"client1", time.Second, false, 0},
+		{"client2", 0, true, 1},
+		// one token is refilled every 5 seconds
+		{"client1", 4 * time.Second, true, 0},
+		{"client1", 0, false, 0},
+	}
+
+	for index, tcase := range testCase {
+		clock = clock.Add(tcase.Advance)
+
+		res := Allow(context.Background(), tcase.Key, limit)
+		if res.Allowed != tcase.ExpectedAllowed {
+			t.Errorf("[TestAllow] tcase:%v allowed got %v | expected %v", index, res.Allowed, tcase.ExpectedAllowed)
+		}
+
+		if res.Remaining != tcase.ExpectedRemaining {
+			t.Errorf("[TestAllow] tcase:%v remaining got %v | expected %v", index, res.Remaining, tcase.ExpectedRemaining)
+		}
+
+		if !res.Allowed && res.RetryAfter <= 0 {
+			t.Errorf("[TestAllow] tcase:%v retry after got %v | expected > 0", index, res.RetryAfter)
+		}
+	}
+}
+
+func TestLocalAllow(t *testing.T) {
+	l := &localLimiter{buckets: make(map[string]*localBucket)}
+
+	// 2 requests per 10 seconds
+	rate := 2.0 / 10000
+
+	testCase := []struct {
+		NowMs           int64
+		ExpectedAllowed bool
+	}{
+		{0, true},
+		{0, true},
+		{1000, false},
+		{5000, true},
+		{5000, false},
+	}
+
+	for index, tcase := range testCase {
+		res := l.allow("client1", 2, rate, tcase.NowMs, 1)
+		if res.Allowed != tcase.ExpectedAllowed {
+			t.Errorf("[TestLocalAllow] tcase:%v allowed got %v | expected %v", index, res.Allowed, tcase.ExpectedAllowed)
+		}
+	}
+}
+
+func TestPeek(t *testing.T) {
+	limit := config.Limit{Requests: 1, Seconds: 10}
+
+	// peek doesn't take the request
+	if res := Peek(context.Background(), "peek1", limit); !res.Allowed || res.Remaining != 1 {
+		t.Errorf("[TestPeek] first peek got %v | expected allowed with 1 remaining", res)
+	}
+
+	Allow(context.Background(), "peek1", limit)
+
+	if res := Peek(context.Background(), "peek1", limit); res.Allowed || res.RetryAfter <= 0 {
+		t.Errorf("[TestPeek] peek of empty bucket got %v | expected not allowed with retry after", res)
+	}
+}
+
+func TestInit(t *testing.T) {
+	testCase := []struct {
+		Conf  config.RateLimit
+		Valid bool
+	}{
+		{config.RateLimit{Enabled: true}, true},
+		{config.RateLimit{Enabled: true, Cache: "missing"}, false},
+		// disabled rate limit doesn't need redis
+		{config.RateLimit{Cache: "missing"}, true},
+	}
+
+	for index, tcase := range testCase {
+		if err := Init(tcase.Conf); (err == nil) != tcase.Valid {
+			t.Errorf("[TestInit] tcase:%v err got %v | expected valid %v", index, err, tcase.Valid)
+		}
+	}
+
+	Init(config.RateLimit{Enabled: true})
+}
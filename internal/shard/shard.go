@@ -0,0 +1,121 @@
+package shard
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+)
+
+const defaultSlots = 256
+
+type (
+	// router hold the owner of every slot
+	router struct {
+		slots  []config.Shard
+		shards []config.Shard
+	}
+)
+
+// current is nil when sharding is disabled
+var current *router
+
+// Configure validate and apply sharding config, empty Shards disable sharding
+func Configure(conf config.Sharding) error {
+	if len(conf.Shards) == 0 {
+		current = nil
+		return nil
+	}
+
+	slots := conf.Slots
+	if slots <= 0 {
+		slots = defaultSlots
+	}
+
+	r := &router{
+		slots:  make([]config.Shard, slots),
+		shards: conf.Shards,
+	}
+
+	for _, s := range conf.Shards {
+		if s.Database == "" || s.From < 0 || s.To >= slots || s.From > s.To {
+			return fmt.Errorf("invalid shard %v slots %v-%v of %v", s.Database, s.From, s.To, slots)
+		}
+
+		for slot := s.From; slot <= s.To; slot++ {
+			if r.slots[slot].Database != "" {
+				return fmt.Errorf("slot %v belongs to %v and %v", slot, r.slots[slot].Database, s.Database)
+			}
+			r.slots[slot] = s
+		}
+	}
+
+	for slot, s := range r.slots {
+		if s.Database == "" {
+			return fmt.Errorf("slot %v doesn't belong to any shard", slot)
+		}
+	}
+
+	current = r
+	return nil
+}
+
+// Enabled report whether contacts are sharded
+func Enabled() bool {
+	return current != nil
+}
+
+// Slot return hash slot of contact id
+func Slot(id int64) int {
+	return slotOf(id, len(current.slots))
+}
+
+// ForID return database that owns contact id, and the one it's moved from during reshard
+func ForID(id int64) (string, string) {
+	s := current.slots[Slot(id)]
+	return s.Database, s.Previous
+}
+
+// Databases return every database that may hold contacts, including ones being moved from
+func Databases() []string {
+	seen := make(map[string]bool)
+	for _, s := range current.shards {
+		seen[s.Database] = true
+		if s.Previous != "" {
+			seen[s.Previous] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Moving return shards whose slots are being moved from Previous
+func Moving() []config.Shard {
+	var moving []config.Shard
+	for _, s := range current.shards {
+		if s.Previous != "" && s.Previous != s.Database {
+			moving = append(moving, s)
+		}
+	}
+
+	return moving
+}
+
+// slotOf hash id rather than taking modulo, so pattern of ids doesn't skew slots
+func slotOf(id int64, slots int) int {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(id))
+
+	h := fnv.New64a()
+	h.Write(b[:])
+
+	return int(h.Sum64() % uint64(slots))
+}
@@ -0,0 +1,77 @@
+package shard
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+)
+
+func TestConfigure(t *testing.T) {
+	testCase := []struct {
+		Conf     config.Sharding
+		Valid    bool
+		Expected bool
+	}{
+		// no shards disable sharding
+		{config.Sharding{}, true, false},
+		{config.Sharding{Slots: 4, Shards: []config.Shard{{Database: "main", From: 0, To: 1}, {Database: "shard1", From: 2, To: 3}}}, true, true},
+		// slot 3 doesn't belong to any shard
+		{config.Sharding{Slots: 4, Shards: []config.Shard{{Database: "main", From: 0, To: 2}}}, false, false},
+		// slot 1 belongs to both shards
+		{config.Sharding{Slots: 4, Shards: []config.Shard{{Database: "main", From: 0, To: 1}, {Database: "shard1", From: 1, To: 3}}}, false, false},
+		{config.Sharding{Slots: 4, Shards: []config.Shard{{Database: "main", From: 0, To: 4}}}, false, false},
+		{config.Sharding{Slots: 4, Shards: []config.Shard{{From: 0, To: 3}}}, false, false},
+	}
+
+	for index, tcase := range testCase {
+		err := Configure(tcase.Conf)
+		if (err == nil) != tcase.Valid {
+			t.Errorf("[TestConfigure] tcase:%v error %v | expected valid %v", index, err, tcase.Valid)
+			continue
+		}
+
+		if tcase.Valid && Enabled() != tcase.Expected {
+			t.Errorf("[TestConfigure] tcase:%v enabled %v | expected %v", index, Enabled(), tcase.Expected)
+		}
+	}
+}
+
+func TestForID(t *testing.T) {
+	err := Configure(config.Sharding{
+		Slots: 8,
+		Shards: []config.Shard{
+			{Database: "main", From: 0, To: 3},
+			{Database: "shard1", From: 4, To: 7, Previous: "main"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("[TestForID] configure error %v", err)
+	}
+	defer Configure(config.Sharding{})
+
+	for id := int64(1); id <= 100; id++ {
+		slot := Slot(id)
+		if slot != Slot(id) {
+			t.Errorf("[TestForID] id:%v slot isn't stable", id)
+		}
+
+		dbname, previous := ForID(id)
+		expected, expectedPrevious := "main", ""
+		if slot >= 4 {
+			expected, expectedPrevious = "shard1", "main"
+		}
+
+		if dbname != expected || previous != expectedPrevious {
+			t.Errorf("[TestForID] id:%v slot:%v got %v,%v | expected %v,%v", id, slot, dbname, previous, expected, expectedPrevious)
+		}
+	}
+
+	if got := Databases(); !reflect.DeepEqual(got, []string{"main", "shard1"}) {
+		t.Errorf("[TestForID] databases got %v | expected %v", got, []string{"main", "shard1"})
+	}
+
+	if got := Moving(); len(got) != 1 || got[0].Database != "shard1" {
+		t.Errorf("[TestForID] moving got %v | expected shard1", got)
+	}
+}
@@ -0,0 +1,176 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/ffjabbari/go-microservice-sample"
+
+// Init is for setup global tracer provider and W3C trace context propagation
+// it returns function that must be called before exit to flush remaining spans
+func Init(conf config.Tracing) (func(context.Context) error, error) {
+
+	// always propagate traceparent, even if we don't export our own spans
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, file, err := newExporter(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	// tracing is disabled, keep the default no-op provider
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	serviceName := conf.ServiceName
+	if serviceName == "" {
+		serviceName = "contactapp"
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(newSampler(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	// file is closed after remaining spans are flushed to it
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// newSampler return sampler of root spans, ratio outside (0, 1) sample everything
+func newSampler(ratio float64) sdktrace.Sampler {
+	if ratio > 0 && ratio < 1 {
+		return sdktrace.TraceIDRatioBased(ratio)
+	}
+
+	return sdktrace.AlwaysSample()
+}
+
+// newExporter return exporter of conf, and the file it writes to for "file" exporter
+func newExporter(conf config.Tracing) (sdktrace.SpanExporter, *os.File, error) {
+	switch conf.Exporter {
+	case "":
+		return nil, nil, nil
+
+	case "otlp":
+		opts := []otlptracegrpc.Option{}
+		if conf.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(conf.Endpoint))
+		}
+		if conf.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(context.Background(), opts...)
+		return exporter, nil, err
+
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, nil, err
+
+	case "file":
+		f, err := os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown tracing exporter %s", conf.Exporter)
+}
+
+// Start is for start new span as child of span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartServer is for start server span of an incoming http request
+// parent span is taken from traceparent header if any
+func StartServer(r *http.Request, route string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+	return otel.Tracer(instrumentationName).Start(ctx, r.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("http.target", r.URL.RequestURI()),
+		),
+	)
+}
+
+// StartSQL is for start client span of one sql statement
+// name is the statement name, like the one used in metrics
+func StartSQL(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, "sql "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", name),
+		),
+	)
+}
+
+// StartRedis is for start client span of one redis command
+func StartRedis(ctx context.Context, command, key string) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, "redis "+command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", command),
+			attribute.String("db.redis.key", key),
+		),
+	)
+}
+
+// SetHTTPStatus is for record response status to server span
+func SetHTTPStatus(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// End is for finish span and record the error if any
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
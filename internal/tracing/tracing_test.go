@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ffjabbari/go-microservice-sample/internal/config"
+)
+
+func TestNewExporter(t *testing.T) {
+	dir := t.TempDir()
+
+	testCase := []struct {
+		Conf           config.Tracing
+		ExpectExporter bool
+		ExpectFile     bool
+		ExpectError    bool
+	}{
+		{config.Tracing{}, false, false, false},
+		{config.Tracing{Exporter: "stdout"}, true, false, false},
+		{config.Tracing{Exporter: "file", File: filepath.Join(dir, "spans.json")}, true, true, false},
+		{config.Tracing{Exporter: "file", File: filepath.Join(dir, "missing", "spans.json")}, false, false, true},
+		{config.Tracing{Exporter: "zipkin"}, false, false, true},
+	}
+
+	for index, tcase := range testCase {
+		exporter, file, err := newExporter(tcase.Conf)
+		if (err != nil) != tcase.ExpectError {
+			t.Errorf("[TestNewExporter] tcase:%v err got %v | expected err!=nil->%v", index, err, tcase.ExpectError)
+		}
+
+		if (exporter != nil) != tcase.ExpectExporter || (file != nil) != tcase.ExpectFile {
+			t.Errorf("[TestNewExporter] tcase:%v got exporter %v file %v | expected exporter %v file %v", index, exporter != nil, file != nil, tcase.ExpectExporter, tcase.ExpectFile)
+		}
+
+		if file != nil {
+			file.Close()
+		}
+	}
+}
+
+func TestNewSampler(t *testing.T) {
+	testCase := []struct {
+		Ratio    float64
+		Expected string
+	}{
+		{0, "AlwaysOnSampler"},
+		{0.5, "TraceIDRatioBased{0.5}"},
+		{1, "AlwaysOnSampler"},
+		{-1, "AlwaysOnSampler"},
+	}
+
+	for index, tcase := range testCase {
+		if got := newSampler(tcase.Ratio).Description(); got != tcase.Expected {
+			t.Errorf("[TestNewSampler] tcase:%v got %v | expected %v", index, got, tcase.Expected)
+		}
+	}
+}
+
+func TestInitFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+
+	shutdown, err := Init(config.Tracing{Exporter: "file", File: path})
+	if err != nil {
+		t.Fatalf("[TestInitFile] init error %v", err)
+	}
+
+	_, span := Start(context.Background(), "test")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("[TestInitFile] shutdown error %v", err)
+	}
+
+	// span is flushed to the file before it's closed
+	content, err := os.ReadFile(path)
+	if err != nil || len(content) == 0 {
+		t.Errorf("[TestInitFile] file got %q, %v | expected exported span", content, err)
+	}
+}